@@ -0,0 +1,78 @@
+package etcd
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"reflect"
+)
+
+// Values of the "encoding" tag option of []byte and [N]byte fields.
+const (
+	bytesBase64 = "base64"
+	bytesHex    = "hex"
+	bytesRaw    = "raw"
+)
+
+// isBytes reports whether values of type t are stored as a single value
+// holding their bytes rather than as a dir with a key per element.
+func isBytes(t reflect.Type) bool {
+	return (t.Kind() == reflect.Slice || t.Kind() == reflect.Array) && t.Elem().Kind() == reflect.Uint8
+}
+
+// bytesToString encodes a []byte or [N]byte value with the "encoding" tag
+// option, base64 by default.
+func bytesToString(value reflect.Value, encoding string) (string, error) {
+	var b []byte
+	if value.Kind() == reflect.Slice {
+		b = value.Bytes()
+	} else {
+		b = make([]byte, value.Len())
+		for i := range b {
+			b[i] = byte(value.Index(i).Uint())
+		}
+	}
+
+	switch encoding {
+	case "", bytesBase64:
+		return base64.StdEncoding.EncodeToString(b), nil
+	case bytesHex:
+		return hex.EncodeToString(b), nil
+	case bytesRaw:
+		return string(b), nil
+	default:
+		return "", fmt.Errorf("unknown bytes encoding %q", encoding)
+	}
+}
+
+// decodeBytes is the inverse of bytesToString.
+func decodeBytes(s string, value reflect.Value, encoding string) error {
+	var b []byte
+	var err error
+	switch encoding {
+	case "", bytesBase64:
+		b, err = base64.StdEncoding.DecodeString(s)
+	case bytesHex:
+		b, err = hex.DecodeString(s)
+	case bytesRaw:
+		b = []byte(s)
+	default:
+		err = fmt.Errorf("unknown bytes encoding %q", encoding)
+	}
+	if err != nil {
+		return err
+	}
+
+	if value.Kind() == reflect.Slice {
+		value.SetBytes(b)
+		return nil
+	}
+
+	if len(b) != value.Len() {
+		return fmt.Errorf("can't decode %d bytes into %s", len(b), value.Type())
+	}
+	for i := range b {
+		value.Index(i).SetUint(uint64(b[i]))
+	}
+	return nil
+}
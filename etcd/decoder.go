@@ -8,6 +8,7 @@ import (
 	"fmt"
 	"log"
 	"reflect"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
@@ -19,18 +20,34 @@ type Decoder interface {
 	Decode(string, interface{}) error
 	DecodeWithContext(string, interface{}, context.Context) error
 	SkipMissing(bool)
+	RegisterCodec(reflect.Type, EncodeFunc, DecodeFunc)
+	RegisterType(string, interface{})
+	RegisterEnum(interface{}) error
 }
 
+// Unmarshaler is implemented by types that read their own subtree, the
+// counterpart of Marshaler. UnmarshalEtcd receives the node stored under the
+// value's path with all its children.
+type Unmarshaler interface {
+	UnmarshalEtcd(*client.Node) error
+}
+
+var textUnmarshalerType = reflect.TypeOf(new(encoding.TextUnmarshaler)).Elem()
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
 type decoderFn func(*client.Node, reflect.Value, context.Context) error
 
 type decoder struct {
 	client      client.KeysAPI
 	skipMissing bool
+	options
 }
 
-func NewDecoder(client client.KeysAPI) Decoder {
+func NewDecoder(client client.KeysAPI, opts ...Option) Decoder {
 	return &decoder{
-		client: client,
+		client:  client,
+		options: newOptions(opts),
 	}
 }
 
@@ -40,6 +57,18 @@ func (d *decoder) SkipMissing(skip bool) {
 	d.skipMissing = skip
 }
 
+func (d *decoder) RegisterCodec(t reflect.Type, encode EncodeFunc, decode DecodeFunc) {
+	d.registry.RegisterCodec(t, encode, decode)
+}
+
+func (d *decoder) RegisterType(name string, v interface{}) {
+	d.registry.RegisterType(name, v)
+}
+
+func (d *decoder) RegisterEnum(values interface{}) error {
+	return d.registry.RegisterEnum(values)
+}
+
 func (d *decoder) Decode(path string, v interface{}) error {
 	return d.DecodeWithContext(path, v, context.Background())
 }
@@ -50,10 +79,10 @@ func (d *decoder) DecodeWithContext(path string, v interface{}, ctx context.Cont
 		return errors.New("destination has to be a pointer")
 	}
 
-	return d.decode(path, value.Elem(), ctx)
+	return d.decode(path, value.Elem(), tagOptions{}, ctx)
 }
 
-func (d *decoder) indirect(v reflect.Value) (json.Unmarshaler, encoding.TextUnmarshaler) {
+func (d *decoder) indirect(v reflect.Value) (Unmarshaler, json.Unmarshaler, encoding.TextUnmarshaler) {
 	if v.Kind() != reflect.Ptr && v.Type().Name() != "" && v.CanAddr() {
 		v = v.Addr()
 	}
@@ -74,35 +103,113 @@ func (d *decoder) indirect(v reflect.Value) (json.Unmarshaler, encoding.TextUnma
 			v.Set(reflect.New(v.Type().Elem()))
 		}
 		if v.Type().NumMethod() > 0 {
+			if u, ok := v.Interface().(Unmarshaler); ok {
+				return u, nil, nil
+			}
 			if u, ok := v.Interface().(json.Unmarshaler); ok {
-				return u, nil
+				return nil, u, nil
 			}
 			if u, ok := v.Interface().(encoding.TextUnmarshaler); ok {
-				return nil, u
+				return nil, nil, u
 			}
 		}
 		v = v.Elem()
 	}
-	return nil, nil
+	return nil, nil, nil
 }
 
-func (d *decoder) decode(path string, value reflect.Value, ctx context.Context) error {
+func (d *decoder) decode(path string, value reflect.Value, tag tagOptions, ctx context.Context) error {
 	node, err := d.getNode(path, ctx)
 	if node == nil {
 		return err
 	}
 
-	decoder := d.decoder(value)
+	decoder := d.decoder(value, tag)
 	return decoder(node, value, ctx)
 }
 
-func (d *decoder) decodeNode(node *client.Node, value reflect.Value, ctx context.Context) error {
-	decoder := d.decoder(value)
+func (d *decoder) decodeNode(node *client.Node, value reflect.Value, tag tagOptions, ctx context.Context) error {
+	decoder := d.decoder(value, tag)
 	return decoder(node, value, ctx)
 }
 
-func (d *decoder) decoder(value reflect.Value) decoderFn {
-	u, tu := d.indirect(value)
+func (d *decoder) decoder(value reflect.Value, tag tagOptions) decoderFn {
+	if tag.has("json") {
+		return func(n *client.Node, v reflect.Value, ctx context.Context) error {
+			return d.decodeJSON(n, v, tag, ctx)
+		}
+	}
+
+	if typeKey := tag.get("typekey"); typeKey != "" && value.Kind() == reflect.Interface {
+		return func(n *client.Node, v reflect.Value, ctx context.Context) error {
+			return d.decodeTyped(n, v, typeKey, ctx)
+		}
+	}
+
+	if decode, ok := d.registry.decoder(value.Type()); ok {
+		return func(n *client.Node, v reflect.Value, ctx context.Context) error {
+			if n.Dir {
+				return errors.New(fmt.Sprintf("%s is a dir", n.Key))
+			}
+			if err := decode(n.Value, v); err != nil {
+				return fmt.Errorf("can't decode %s: %s", n.Key, err)
+			}
+			return nil
+		}
+	}
+
+	if en, err := d.registry.enum(value.Type()); err != nil {
+		return func(n *client.Node, v reflect.Value, ctx context.Context) error {
+			return fmt.Errorf("can't decode %s: %s", n.Key, err)
+		}
+	} else if en != nil {
+		return func(n *client.Node, v reflect.Value, ctx context.Context) error {
+			if n.Dir {
+				return errors.New(fmt.Sprintf("%s is a dir", n.Key))
+			}
+			if err := en.decode(n.Value, v, d.numericEnums); err != nil {
+				return fmt.Errorf("can't decode %s: %s", n.Key, err)
+			}
+			return nil
+		}
+	}
+
+	if value.Kind() == reflect.Ptr {
+		return func(n *client.Node, v reflect.Value, ctx context.Context) error {
+			return d.decodePointer(n, v, tag, ctx)
+		}
+	}
+
+	if unit := tag.get("unit"); unit != "" {
+		return func(n *client.Node, v reflect.Value, ctx context.Context) error {
+			err := decodeUnit(n.Value, v, unit)
+			if _, ok := err.(*RangeError); ok {
+				return withKey(err, n.Key)
+			} else if err != nil {
+				return fmt.Errorf("can't decode %s: %s", n.Key, err)
+			}
+			return nil
+		}
+	}
+
+	if value.Type() == timeType {
+		return func(n *client.Node, v reflect.Value, ctx context.Context) error {
+			t, err := parseTime(n.Value, tag.get("layout"))
+			if err != nil {
+				return fmt.Errorf("can't decode %s: %s", n.Key, err)
+			}
+			v.Set(reflect.ValueOf(t))
+			return nil
+		}
+	}
+
+	eu, u, tu := d.indirect(value)
+	if eu != nil {
+		return func(n *client.Node, v reflect.Value, ctx context.Context) error {
+			return d.decodeEtcdUnmarshaler(eu, n, ctx)
+		}
+	}
+
 	if u != nil {
 		return func(n *client.Node, v reflect.Value, ctx context.Context) error {
 			return d.decodeUnmarshaler(u, n, ctx)
@@ -126,9 +233,6 @@ func (d *decoder) decoder(value reflect.Value) decoderFn {
 			return errors.New("can't decode channel: not implemented")
 		}
 
-	case reflect.Ptr:
-		return d.decodePointer
-
 	case reflect.Interface:
 		return d.decodeInterface
 
@@ -139,28 +243,55 @@ func (d *decoder) decoder(value reflect.Value) decoderFn {
 		return d.decodeMap
 
 	case reflect.Slice, reflect.Array:
+		if isBytes(value.Type()) {
+			return func(n *client.Node, v reflect.Value, ctx context.Context) error {
+				if n.Dir {
+					// Earlier versions stored a key per byte.
+					return d.decodeSlice(n, v, ctx)
+				}
+				if err := decodeBytes(n.Value, v, tag.get("encoding")); err != nil {
+					return fmt.Errorf("can't decode %s: %s", n.Key, err)
+				}
+				return nil
+			}
+		}
+		if key := tag.get("key"); key != "" && value.Kind() == reflect.Slice {
+			return func(n *client.Node, v reflect.Value, ctx context.Context) error {
+				return d.decodeKeyedSlice(n, v, key, ctx)
+			}
+		}
+		if tag.has("inorder") && value.Kind() == reflect.Slice {
+			return d.decodeInOrderSlice
+		}
 		return d.decodeSlice
 
 	default:
+		if d.weaklyTyped {
+			return func(n *client.Node, v reflect.Value, ctx context.Context) error {
+				return withKey(decodeWeak(n.Value, v), n.Key)
+			}
+		}
 		return func(n *client.Node, v reflect.Value, ctx context.Context) error {
-			return decodePrimitive(n.Value, v)
+			return withKey(decodePrimitive(n.Value, v), n.Key)
 		}
 	}
-
-	return nil
 }
 
-func (d *decoder) decodePointer(node *client.Node, value reflect.Value, ctx context.Context) error {
+func (d *decoder) decodePointer(node *client.Node, value reflect.Value, tag tagOptions, ctx context.Context) error {
 	if value.IsNil() {
 		value.Set(reflect.New(value.Type().Elem()))
 	}
 
-	return d.decodeNode(node, value.Elem(), ctx)
+	return d.decodeNode(node, value.Elem(), tag, ctx)
 }
 
 func (d *decoder) decodeInterface(node *client.Node, value reflect.Value, ctx context.Context) error {
+	if value.IsNil() {
+		return fmt.Errorf("can't decode %s into nil %s, use typekey tag option", node.Key, value.Type())
+	}
+
 	v := reflect.New(value.Elem().Type()).Elem()
-	if err := d.decodeNode(node, v, ctx); err != nil {
+	if err := d.decodeNode(node, v, tagOptions{}, ctx); err != nil {
 		return err
 	}
 
@@ -168,6 +299,67 @@ func (d *decoder) decodeInterface(node *client.Node, value reflect.Value, ctx co
 	return nil
 }
 
+// decodeTyped reads an interface field tagged with the "typekey" option: the
+// concrete type is looked up by the name stored under typeKey.
+func (d *decoder) decodeTyped(node *client.Node, value reflect.Value, typeKey string, ctx context.Context) error {
+	if !node.Dir {
+		return errors.New(fmt.Sprintf("%s is not a dir", node.Key))
+	}
+
+	var name string
+	found := false
+	for _, n := range node.Nodes {
+		if n.Key == fmt.Sprintf("%s/%s", node.Key, typeKey) {
+			name, found = n.Value, true
+			break
+		}
+	}
+	if !found {
+		return fmt.Errorf("Key %s not found", fmt.Sprintf("%s/%s", node.Key, typeKey))
+	}
+
+	t, ok := d.registry.typeByName(name)
+	if !ok {
+		return fmt.Errorf("can't decode %s: type %q is not registered", node.Key, name)
+	}
+	if !t.AssignableTo(value.Type()) {
+		return fmt.Errorf("can't decode %s: %s does not implement %s", node.Key, t, value.Type())
+	}
+
+	v := reflect.New(t).Elem()
+	if err := d.decodeNode(node, v, tagOptions{}, ctx); err != nil {
+		return err
+	}
+
+	value.Set(v)
+	return nil
+}
+
+// decodeJSON reads a field tagged with the "json" option. Values that are
+// still stored in the tree layout are decoded as if the option was not set.
+func (d *decoder) decodeJSON(node *client.Node, value reflect.Value, tag tagOptions, ctx context.Context) error {
+	if node.Dir {
+		return d.decodeNode(node, value, tag.without("json"), ctx)
+	}
+
+	if err := json.Unmarshal([]byte(node.Value), value.Addr().Interface()); err != nil {
+		return fmt.Errorf("can't decode %s: %s", node.Key, err)
+	}
+	return nil
+}
+
+func (d *decoder) decodeEtcdUnmarshaler(u Unmarshaler, node *client.Node, ctx context.Context) error {
+	if node.Dir {
+		r, err := d.client.Get(ctx, node.Key, &client.GetOptions{Recursive: true, Sort: true})
+		if err != nil {
+			return err
+		}
+		node = r.Node
+	}
+
+	return u.UnmarshalEtcd(node)
+}
+
 func (d *decoder) decodeUnmarshaler(u json.Unmarshaler, node *client.Node, ctx context.Context) error {
 	return u.UnmarshalJSON([]byte(node.Value))
 }
@@ -177,36 +369,158 @@ func (d *decoder) decodeTextUnmarshaler(u encoding.TextUnmarshaler, node *client
 }
 
 func (d *decoder) decodeSlice(node *client.Node, value reflect.Value, ctx context.Context) error {
+	if !node.Dir && d.weaklyTyped && value.Kind() == reflect.Slice {
+		if strings.TrimSpace(node.Value) == "" {
+			value.Set(reflect.Zero(value.Type()))
+			return nil
+		}
+		el := reflect.New(value.Type().Elem()).Elem()
+		if err := d.decodeNode(node, el, tagOptions{}, ctx); err != nil {
+			return err
+		}
+		value.Set(reflect.Append(reflect.MakeSlice(value.Type(), 0, 1), el))
+		return nil
+	}
 	if !node.Dir {
 		return errors.New(fmt.Sprintf("%s is not a dir", node.Key))
 	}
 
-	if value.IsNil() || value.Len() == 0 {
-		value.Set(reflect.MakeSlice(value.Type(), len(node.Nodes), len(node.Nodes)))
+	// Indices may be zero-padded ("0001") or not ("1"), and etcd returns them
+	// in lexical order, so parse them all before sizing the slice.
+	indices := make([]int, len(node.Nodes))
+	length := 0
+	for i, node := range node.Nodes {
+		tmp := strings.Split(node.Key, "/")
+		index, err := strconv.Atoi(tmp[len(tmp)-1])
+		if err != nil || index < 0 {
+			return fmt.Errorf("%s is not a valid slice index", node.Key)
+		}
+		indices[i] = index
+		if index >= length {
+			length = index + 1
+		}
 	}
 
-	for _, node := range node.Nodes {
+	if value.Kind() == reflect.Array {
+		if length > value.Len() {
+			return fmt.Errorf("%s has %d elements, array %s only fits %d", node.Key, length, value.Type(), value.Len())
+		}
+	} else if value.IsNil() || value.Len() < length {
+		value.Set(reflect.MakeSlice(value.Type(), length, length))
+	}
+
+	for i, node := range node.Nodes {
 		sliceValue := reflect.New(value.Type().Elem()).Elem()
 		if node.Dir {
-			if err := d.decode(node.Key, sliceValue, ctx); err != nil {
+			if err := d.decode(node.Key, sliceValue, tagOptions{}, ctx); err != nil {
 				return err
 			}
 		} else {
-			if err := d.decodeNode(node, sliceValue, ctx); err != nil {
+			if err := d.decodeNode(node, sliceValue, tagOptions{}, ctx); err != nil {
 				return err
 			}
 		}
 
-		tmp := strings.Split(node.Key, "/")
-		index, _ := strconv.Atoi(tmp[len(tmp)-1])
-
-		el := value.Index(index)
+		el := value.Index(indices[i])
 		el.Set(sliceValue)
 	}
 
 	return nil
 }
 
+// decodeKeyedSlice reads a slice stored with the "key=Field" tag option. Every
+// child node is an element named after its key field; elements are returned
+// sorted by that name.
+func (d *decoder) decodeKeyedSlice(node *client.Node, value reflect.Value, key string, ctx context.Context) error {
+	if !node.Dir {
+		return errors.New(fmt.Sprintf("%s is not a dir", node.Key))
+	}
+
+	nodes := make(client.Nodes, len(node.Nodes))
+	copy(nodes, node.Nodes)
+	sort.Sort(nodes)
+
+	slice := reflect.MakeSlice(value.Type(), 0, len(nodes))
+	for _, node := range nodes {
+		sliceValue := reflect.New(value.Type().Elem()).Elem()
+		if err := d.decode(node.Key, sliceValue, tagOptions{}, ctx); err != nil {
+			return err
+		}
+
+		el := sliceValue
+		for el.Kind() == reflect.Ptr {
+			el = el.Elem()
+		}
+		if el.Kind() != reflect.Struct {
+			return fmt.Errorf("can't key slice element of type %s by %s: not a struct", el.Type(), key)
+		}
+		field := el.FieldByName(key)
+		if !field.IsValid() {
+			return fmt.Errorf("%s has no field %s", el.Type(), key)
+		}
+		p := strings.Split(node.Key, "/")
+		name, err := d.keyEscaper.Unescape(p[len(p)-1])
+		if err != nil {
+			return fmt.Errorf("can't unescape key %s: %s", node.Key, err)
+		}
+		if err := decodeKey(name, field); err != nil {
+			return fmt.Errorf("can't decode key %s: %s", node.Key, err)
+		}
+
+		slice = reflect.Append(slice, sliceValue)
+	}
+
+	value.Set(slice)
+	return nil
+}
+
+// decodeInOrderSlice reads a slice stored with the "inorder" tag option, in
+// the order its elements were created.
+func (d *decoder) decodeInOrderSlice(node *client.Node, value reflect.Value, ctx context.Context) error {
+	if !node.Dir {
+		return errors.New(fmt.Sprintf("%s is not a dir", node.Key))
+	}
+
+	nodes := make(client.Nodes, len(node.Nodes))
+	copy(nodes, node.Nodes)
+	sort.SliceStable(nodes, func(i, j int) bool {
+		return nodes[i].CreatedIndex < nodes[j].CreatedIndex
+	})
+
+	slice := reflect.MakeSlice(value.Type(), 0, len(nodes))
+	for _, node := range nodes {
+		sliceValue := reflect.New(value.Type().Elem()).Elem()
+		if err := d.decodeValue(node, sliceValue, ctx); err != nil {
+			return err
+		}
+		slice = reflect.Append(slice, sliceValue)
+	}
+
+	value.Set(slice)
+	return nil
+}
+
+// decodeValue decodes an element of an in-order slice, which holds structs,
+// maps and slices as JSON.
+func (d *decoder) decodeValue(node *client.Node, value reflect.Value, ctx context.Context) error {
+	if node.Dir {
+		return d.decode(node.Key, value, tagOptions{}, ctx)
+	}
+
+	if _, ok := d.registry.decoder(value.Type()); ok {
+		return d.decodeNode(node, value, tagOptions{}, ctx)
+	}
+
+	if eu, u, tu := d.indirect(value); eu == nil && u == nil && tu == nil && isComposite(value.Type()) {
+		if err := json.Unmarshal([]byte(node.Value), value.Addr().Interface()); err != nil {
+			return fmt.Errorf("can't decode %s: %s", node.Key, err)
+		}
+		return nil
+	}
+
+	return d.decodeNode(node, value, tagOptions{}, ctx)
+}
+
 func (d *decoder) decodeMap(node *client.Node, value reflect.Value, ctx context.Context) error {
 	if !node.Dir {
 		return errors.New(fmt.Sprintf("%s is not a dir", node.Key))
@@ -219,18 +533,24 @@ func (d *decoder) decodeMap(node *client.Node, value reflect.Value, ctx context.
 	for _, node := range node.Nodes {
 		mapValue := reflect.New(value.Type().Elem()).Elem()
 		if node.Dir {
-			if err := d.decode(node.Key, mapValue, ctx); err != nil {
+			if err := d.decode(node.Key, mapValue, tagOptions{}, ctx); err != nil {
 				return err
 			}
 		} else {
-			if err := d.decodeNode(node, mapValue, ctx); err != nil {
+			if err := d.decodeNode(node, mapValue, tagOptions{}, ctx); err != nil {
 				return err
 			}
 		}
 
 		mapKey := reflect.New(value.Type().Key()).Elem()
 		p := strings.Split(node.Key, "/")
-		decodePrimitive(p[len(p)-1], mapKey)
+		name, err := d.keyEscaper.Unescape(p[len(p)-1])
+		if err != nil {
+			return fmt.Errorf("can't unescape key %s: %s", node.Key, err)
+		}
+		if err := decodeKey(name, mapKey); err != nil {
+			return fmt.Errorf("can't decode map key %s: %s", node.Key, err)
+		}
 		value.SetMapIndex(mapKey, mapValue)
 	}
 
@@ -248,38 +568,95 @@ func (d *decoder) decodeStruct(top *client.Node, value reflect.Value, ctx contex
 		nodes[p[len(p)-1]] = node
 	}
 
-	for i := 0; i < value.NumField(); i++ {
-		typeField := value.Type().Field(i)
-		name := typeField.Name
-		tag := typeField.Tag.Get("etcd")
-		if tag != "-" {
-			params := strings.Split(tag, ",")
-			if len(params) > 0 && params[0] != "" {
-				name = params[0]
+	for _, f := range typeFields(value.Type(), d.options) {
+		fieldPath := fmt.Sprintf("%s/%s", top.Key, f.name)
+		node, ok := nodes[f.name]
+		if !ok && d.ignoreCase {
+			node, ok = lookupFold(nodes, f.name)
+		}
+		if !ok {
+			node, ok = lookupAlias(nodes, fieldPath, f)
+		}
+		if !ok && f.tag.has("required") {
+			return &ValidationError{Key: fieldPath, Err: errors.New("key is required")}
+		}
+		if !ok && !f.hasDefault {
+			if isOmitEmpty(f.tag) {
+				continue
 			}
-
-			node, ok := nodes[name]
-			if !ok {
-				if isOmitEmpty(params) {
-					continue
+			if f.typ == reflect.PtrTo(timeType) {
+				// A nil *time.Time is stored as a missing key.
+				if fieldValue, ok := fieldByIndex(value, f.index); ok {
+					fieldValue.Set(reflect.Zero(f.typ))
 				}
-				return fmt.Errorf("Key %s not found", fmt.Sprintf("%s/%s", top.Key, name))
+				continue
 			}
+			return fmt.Errorf("Key %s not found", fieldPath)
+		}
 
-			if node.Dir {
-				if err := d.decode(node.Key, value.Field(i), ctx); err != nil {
-					if !canOmitEmpty(err, params) {
-						return err
-					}
-				}
-			} else {
-				if err := d.decodeNode(node, value.Field(i), ctx); err != nil {
+		fieldValue, err := allocFieldByIndex(value, f.index)
+		if err != nil {
+			return err
+		}
+
+		if !ok {
+			if err := d.decodeDefault(fieldPath, fieldValue, f, ctx); err != nil {
+				return err
+			}
+		} else if node.Dir {
+			if err := d.decode(node.Key, fieldValue, f.tag, ctx); err != nil {
+				if !canOmitEmpty(err, f.tag) {
 					return err
 				}
+				continue
 			}
+		} else {
+			if err := d.decodeNode(node, fieldValue, f.tag, ctx); err != nil {
+				return err
+			}
+		}
+
+		if err := validateField(fieldPath, fieldValue, f.tag); err != nil {
+			return err
+		}
+	}
+
+	if err := afterDecode(top.Key, value, ctx); err != nil {
+		return err
+	}
+
+	return validateStruct(top.Key, value)
+}
+
+// lookupFold returns the node whose key equals name ignoring case.
+func lookupFold(nodes map[string]*client.Node, name string) (*client.Node, bool) {
+	for key, node := range nodes {
+		if strings.EqualFold(key, name) {
+			return node, true
 		}
 	}
+	return nil, false
+}
+
+// lookupAlias returns the node stored under the first alias of field f that
+// is present, warning that the field is stored under its old name.
+func lookupAlias(nodes map[string]*client.Node, path string, f field) (*client.Node, bool) {
+	for _, alias := range strings.Fields(f.tag.get("alias")) {
+		if node, ok := nodes[alias]; ok {
+			log.Printf("%s is missing, using deprecated alias %s", path, node.Key)
+			return node, true
+		}
+	}
+	return nil, false
+}
 
+// decodeDefault decodes the `default:"..."` tag of field f into value as if
+// it was stored under path.
+func (d *decoder) decodeDefault(path string, value reflect.Value, f field, ctx context.Context) error {
+	node := &client.Node{Key: path, Value: f.defaultValue}
+	if err := d.decodeNode(node, value, f.tag, ctx); err != nil {
+		return fmt.Errorf("invalid default for %s: %s", path, err)
+	}
 	return nil
 }
 
@@ -299,39 +676,98 @@ func (d *decoder) getNode(path string, ctx context.Context) (*client.Node, error
 	return r.Node, nil
 }
 
+// decodeKey is the inverse of keyToString.
+func decodeKey(s string, key reflect.Value) error {
+	if key.Kind() != reflect.String && reflect.PtrTo(key.Type()).Implements(textUnmarshalerType) {
+		return key.Addr().Interface().(encoding.TextUnmarshaler).UnmarshalText([]byte(s))
+	}
+
+	switch key.Kind() {
+	case reflect.String, reflect.Bool, reflect.Float32, reflect.Float64,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return decodePrimitive(s, key)
+	}
+
+	return fmt.Errorf("unsupported map key type %s", key.Type())
+}
+
+// RangeError is returned by the decoder when a stored number doesn't fit
+// into the destination type.
+type RangeError struct {
+	Key   string
+	Value string
+	Type  reflect.Type
+}
+
+func (e *RangeError) Error() string {
+	if e.Key == "" {
+		return fmt.Sprintf("value %s is out of range for %s", e.Value, e.Type)
+	}
+	return fmt.Sprintf("%s: value %s is out of range for %s", e.Key, e.Value, e.Type)
+}
+
+// decodePrimitive parses nodeValue with the bit size of the destination and
+// returns a *RangeError if it doesn't fit.
 func decodePrimitive(nodeValue string, value reflect.Value) error {
+	rangeError := func(err error) error {
+		if ne, ok := err.(*strconv.NumError); ok && ne.Err == strconv.ErrRange {
+			return &RangeError{Value: nodeValue, Type: value.Type()}
+		}
+		return err
+	}
+
 	switch value.Kind() {
 	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
 		switch value.Interface().(type) {
 		case time.Duration:
-			v, err := time.ParseDuration(nodeValue)
+			v, err := parseDuration(nodeValue)
 			if err != nil {
 				return err
 			}
 			value.SetInt(int64(v))
 
 		default:
-			v, err := strconv.ParseInt(nodeValue, 10, 64)
+			v, err := strconv.ParseInt(nodeValue, 10, value.Type().Bits())
 			if err != nil {
-				return err
+				return rangeError(err)
+			}
+			if value.OverflowInt(v) {
+				return &RangeError{Value: nodeValue, Type: value.Type()}
 			}
 			value.SetInt(v)
 		}
 
 	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
-		v, err := strconv.ParseUint(nodeValue, 10, 64)
+		v, err := strconv.ParseUint(nodeValue, 10, value.Type().Bits())
 		if err != nil {
-			return err
+			return rangeError(err)
+		}
+		if value.OverflowUint(v) {
+			return &RangeError{Value: nodeValue, Type: value.Type()}
 		}
 		value.SetUint(v)
 
 	case reflect.Float32, reflect.Float64:
-		v, err := strconv.ParseFloat(nodeValue, 64)
+		v, err := strconv.ParseFloat(nodeValue, value.Type().Bits())
 		if err != nil {
-			return err
+			return rangeError(err)
+		}
+		if value.OverflowFloat(v) {
+			return &RangeError{Value: nodeValue, Type: value.Type()}
 		}
 		value.SetFloat(v)
 
+	case reflect.Complex64, reflect.Complex128:
+		v, err := parseComplex(nodeValue, value.Type().Bits())
+		if err != nil {
+			return rangeError(err)
+		}
+		if value.OverflowComplex(v) {
+			return &RangeError{Value: nodeValue, Type: value.Type()}
+		}
+		value.SetComplex(v)
+
 	case reflect.Bool:
 		v, err := strconv.ParseBool(nodeValue)
 		if err != nil {
@@ -347,16 +783,21 @@ func decodePrimitive(nodeValue string, value reflect.Value) error {
 	return nil
 }
 
-func isOmitEmpty(params []string) bool {
-	if len(params) < 2 {
-		return false
+// withKey sets the key of a *RangeError returned for the value stored under
+// key.
+func withKey(err error, key string) error {
+	if re, ok := err.(*RangeError); ok && re.Key == "" {
+		re.Key = key
 	}
+	return err
+}
 
-	return params[1] == "omitempty"
+func isOmitEmpty(tag tagOptions) bool {
+	return tag.has("omitempty")
 }
 
-func canOmitEmpty(err error, params []string) bool {
-	if e, ok := err.(client.Error); ok && isOmitEmpty(params) && e.Code == client.ErrorCodeKeyNotFound {
+func canOmitEmpty(err error, tag tagOptions) bool {
+	if e, ok := err.(client.Error); ok && isOmitEmpty(tag) && e.Code == client.ErrorCodeKeyNotFound {
 		return true
 	}
 
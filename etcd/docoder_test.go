@@ -1,6 +1,18 @@
 package etcd
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"net"
+	"net/url"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
@@ -342,3 +354,1180 @@ func TestDecodeOmitEmptyError(t *testing.T) {
 	assert.Nil(t, err)
 	assert.Equal(t, s.Field2, int64(10))
 }
+
+func TestDecodePaddedSlice(t *testing.T) {
+	etcd := new(test.KeysAPIMock)
+	etcd.On("Get", mock.Anything, "/path/to/slice", mock.AnythingOfType("*client.GetOptions")).Return(&client.Response{Node: &client.Node{
+		Dir: true,
+		Nodes: []*client.Node{
+			&client.Node{Key: "/path/to/slice/0", Value: "10"},
+			&client.Node{Key: "/path/to/slice/10", Value: "110"},
+			&client.Node{Key: "/path/to/slice/0002", Value: "30"},
+			&client.Node{Key: "/path/to/slice/0001", Value: "20"},
+		},
+	}}, nil)
+
+	var s []int
+
+	decoder := NewDecoder(etcd)
+	err := decoder.Decode("/path/to/slice", &s)
+	assert.Nil(t, err)
+	assert.Equal(t, 11, len(s))
+	assert.Equal(t, 10, s[0])
+	assert.Equal(t, 20, s[1])
+	assert.Equal(t, 30, s[2])
+	assert.Equal(t, 110, s[10])
+}
+
+func TestDecodeSliceInvalidIndex(t *testing.T) {
+	etcd := new(test.KeysAPIMock)
+	etcd.On("Get", mock.Anything, "/path/to/slice", mock.AnythingOfType("*client.GetOptions")).Return(&client.Response{Node: &client.Node{
+		Dir: true,
+		Nodes: []*client.Node{
+			&client.Node{Key: "/path/to/slice/foo", Value: "10"},
+		},
+	}}, nil)
+
+	var s []int
+
+	decoder := NewDecoder(etcd)
+	err := decoder.Decode("/path/to/slice", &s)
+	assert.Equal(t, "/path/to/slice/foo is not a valid slice index", err.Error())
+}
+
+func TestDecodeKeyedSlice(t *testing.T) {
+	etcd := new(test.KeysAPIMock)
+	etcd.On("Get", mock.Anything, "/path/to/struct", mock.AnythingOfType("*client.GetOptions")).Return(&client.Response{Node: &client.Node{
+		Dir: true,
+		Nodes: []*client.Node{
+			&client.Node{Key: "/path/to/struct/backends", Dir: true},
+		},
+	}}, nil)
+	etcd.On("Get", mock.Anything, "/path/to/struct/backends", mock.AnythingOfType("*client.GetOptions")).Return(&client.Response{Node: &client.Node{
+		Dir: true,
+		Nodes: []*client.Node{
+			&client.Node{Key: "/path/to/struct/backends/b", Dir: true},
+			&client.Node{Key: "/path/to/struct/backends/a", Dir: true},
+		},
+	}}, nil)
+	etcd.On("Get", mock.Anything, "/path/to/struct/backends/a", mock.AnythingOfType("*client.GetOptions")).Return(&client.Response{Node: &client.Node{
+		Key: "/path/to/struct/backends/a",
+		Dir: true,
+		Nodes: []*client.Node{
+			&client.Node{Key: "/path/to/struct/backends/a/Name", Value: "a"},
+			&client.Node{Key: "/path/to/struct/backends/a/Port", Value: "80"},
+		},
+	}}, nil)
+	etcd.On("Get", mock.Anything, "/path/to/struct/backends/b", mock.AnythingOfType("*client.GetOptions")).Return(&client.Response{Node: &client.Node{
+		Key: "/path/to/struct/backends/b",
+		Dir: true,
+		Nodes: []*client.Node{
+			&client.Node{Key: "/path/to/struct/backends/b/Name", Value: "b"},
+			&client.Node{Key: "/path/to/struct/backends/b/Port", Value: "81"},
+		},
+	}}, nil)
+
+	var s = struct {
+		Backends []keyedBackend `etcd:"backends,key=Name"`
+	}{}
+
+	decoder := NewDecoder(etcd)
+	err := decoder.Decode("/path/to/struct", &s)
+	assert.Nil(t, err)
+	assert.Equal(t, []keyedBackend{{Name: "a", Port: 80}, {Name: "b", Port: 81}}, s.Backends)
+}
+
+func TestDecodeInOrderSlice(t *testing.T) {
+	etcd := new(test.KeysAPIMock)
+	etcd.On("Get", mock.Anything, "/path/to/struct", mock.AnythingOfType("*client.GetOptions")).Return(&client.Response{Node: &client.Node{
+		Key: "/path/to/struct",
+		Dir: true,
+		Nodes: []*client.Node{
+			&client.Node{Key: "/path/to/struct/jobs", Dir: true},
+			&client.Node{Key: "/path/to/struct/log", Dir: true},
+		},
+	}}, nil)
+	etcd.On("Get", mock.Anything, "/path/to/struct/jobs", mock.AnythingOfType("*client.GetOptions")).Return(&client.Response{Node: &client.Node{
+		Key: "/path/to/struct/jobs",
+		Dir: true,
+		Nodes: []*client.Node{
+			&client.Node{Key: "/path/to/struct/jobs/00000000000000000012", Value: `{"Name":"b","Port":81}`, CreatedIndex: 12},
+			&client.Node{Key: "/path/to/struct/jobs/00000000000000000009", Value: `{"Name":"a","Port":80}`, CreatedIndex: 9},
+		},
+	}}, nil)
+	etcd.On("Get", mock.Anything, "/path/to/struct/log", mock.AnythingOfType("*client.GetOptions")).Return(&client.Response{Node: &client.Node{
+		Key: "/path/to/struct/log",
+		Dir: true,
+		Nodes: []*client.Node{
+			&client.Node{Key: "/path/to/struct/log/00000000000000000007", Value: "second", CreatedIndex: 7},
+			&client.Node{Key: "/path/to/struct/log/00000000000000000005", Value: "first", CreatedIndex: 5},
+		},
+	}}, nil)
+
+	var s = struct {
+		Jobs []keyedBackend `etcd:"jobs,inorder"`
+		Log  []string       `etcd:"log,inorder"`
+	}{}
+
+	decoder := NewDecoder(etcd)
+	err := decoder.Decode("/path/to/struct", &s)
+	assert.Nil(t, err)
+	assert.Equal(t, []keyedBackend{{Name: "a", Port: 80}, {Name: "b", Port: 81}}, s.Jobs)
+	assert.Equal(t, []string{"first", "second"}, s.Log)
+}
+
+func TestDecodeMapUnescapesKeys(t *testing.T) {
+	etcd := new(test.KeysAPIMock)
+	etcd.On("Get", mock.Anything, "/path/to/some/map", mock.AnythingOfType("*client.GetOptions")).Return(&client.Response{Node: &client.Node{
+		Dir: true,
+		Nodes: []*client.Node{
+			&client.Node{Key: "/path/to/some/map/a%2Fb", Value: "10"},
+			&client.Node{Key: "/path/to/some/map/plain", Value: "20"},
+		},
+	}}, nil)
+
+	var m map[string]int
+
+	decoder := NewDecoder(etcd, WithKeyEscaper(PercentEscaper))
+	err := decoder.Decode("/path/to/some/map", &m)
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]int{"a/b": 10, "plain": 20}, m)
+}
+
+func TestDecodeMapLegacyKeys(t *testing.T) {
+	etcd := new(test.KeysAPIMock)
+	etcd.On("Get", mock.Anything, "/path/to/some/map", mock.AnythingOfType("*client.GetOptions")).Return(&client.Response{Node: &client.Node{
+		Dir: true,
+		Nodes: []*client.Node{
+			&client.Node{Key: "/path/to/some/map/50%off", Value: "10"},
+			&client.Node{Key: "/path/to/some/map/a%20b", Value: "20"},
+		},
+	}}, nil)
+
+	var m map[string]int
+
+	decoder := NewDecoder(etcd)
+	err := decoder.Decode("/path/to/some/map", &m)
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]int{"50%off": 10, "a%20b": 20}, m)
+}
+
+func TestDecodeMapBase32Keys(t *testing.T) {
+	etcd := new(test.KeysAPIMock)
+	etcd.On("Get", mock.Anything, "/path/to/some/map", mock.AnythingOfType("*client.GetOptions")).Return(&client.Response{Node: &client.Node{
+		Dir: true,
+		Nodes: []*client.Node{
+			&client.Node{Key: "/path/to/some/map/C4NM4", Value: "10"},
+		},
+	}}, nil)
+
+	var m map[string]int
+
+	decoder := NewDecoder(etcd, WithKeyEscaper(Base32Escaper))
+	err := decoder.Decode("/path/to/some/map", &m)
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]int{"a/b": 10}, m)
+}
+
+type textKey struct {
+	A, B string
+}
+
+func (k *textKey) UnmarshalText(data []byte) error {
+	p := strings.SplitN(string(data), ":", 2)
+	if len(p) != 2 {
+		return fmt.Errorf("invalid key %s", data)
+	}
+	k.A, k.B = p[0], p[1]
+	return nil
+}
+
+func TestDecodeMapWithNonStringKeys(t *testing.T) {
+	etcd := new(test.KeysAPIMock)
+	etcd.On("Get", mock.Anything, "/path/to/some/map", mock.AnythingOfType("*client.GetOptions")).Return(&client.Response{Node: &client.Node{
+		Dir: true,
+		Nodes: []*client.Node{
+			&client.Node{Key: "/path/to/some/map/true", Value: "10"},
+			&client.Node{Key: "/path/to/some/map/a:b", Value: "20"},
+		},
+	}}, nil)
+	decoder := NewDecoder(etcd)
+
+	var a map[bool]int
+	err := decoder.Decode("/path/to/some/map", &a)
+	assert.Equal(t, `can't decode map key /path/to/some/map/a:b: strconv.ParseBool: parsing "a:b": invalid syntax`, err.Error())
+
+	var b map[textKey]int
+	err = decoder.Decode("/path/to/some/map", &b)
+	assert.Equal(t, "can't decode map key /path/to/some/map/true: invalid key true", err.Error())
+}
+
+func TestDecodeMapWithNumericKeys(t *testing.T) {
+	etcd := new(test.KeysAPIMock)
+	etcd.On("Get", mock.Anything, "/path/to/some/map", mock.AnythingOfType("*client.GetOptions")).Return(&client.Response{Node: &client.Node{
+		Dir: true,
+		Nodes: []*client.Node{
+			&client.Node{Key: "/path/to/some/map/10", Value: "10"},
+			&client.Node{Key: "/path/to/some/map/-20", Value: "20"},
+		},
+	}}, nil)
+	decoder := NewDecoder(etcd)
+
+	var a map[int]int
+	err := decoder.Decode("/path/to/some/map", &a)
+	assert.Nil(t, err)
+	assert.Equal(t, map[int]int{10: 10, -20: 20}, a)
+
+	var b map[float64]int
+	err = decoder.Decode("/path/to/some/map", &b)
+	assert.Nil(t, err)
+	assert.Equal(t, map[float64]int{10: 10, -20: 20}, b)
+}
+
+func TestDecodeEmbeddedStruct(t *testing.T) {
+	etcd := new(test.KeysAPIMock)
+	etcd.On("Get", mock.Anything, "/path/to/some/struct", mock.AnythingOfType("*client.GetOptions")).Return(&client.Response{Node: &client.Node{
+		Dir: true,
+		Nodes: []*client.Node{
+			&client.Node{Key: "/path/to/some/struct/ID", Value: "1"},
+			&client.Node{Key: "/path/to/some/struct/Name", Value: "outer"},
+		},
+	}}, nil)
+
+	var s = struct {
+		*EmbeddedBase
+		Name string
+	}{}
+
+	decoder := NewDecoder(etcd)
+	err := decoder.Decode("/path/to/some/struct", &s)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, s.ID)
+	assert.Equal(t, "", s.EmbeddedBase.Name)
+	assert.Equal(t, "outer", s.Name)
+}
+
+func TestDecodeSkipsUnexportedFields(t *testing.T) {
+	etcd := new(test.KeysAPIMock)
+	etcd.On("Get", mock.Anything, "/path/to/some/struct", mock.AnythingOfType("*client.GetOptions")).Return(&client.Response{Node: &client.Node{
+		Dir: true,
+		Nodes: []*client.Node{
+			&client.Node{Key: "/path/to/some/struct/Field", Value: "10"},
+			&client.Node{Key: "/path/to/some/struct/Exported", Value: "20"},
+			&client.Node{Key: "/path/to/some/struct/hidden", Value: "30"},
+		},
+	}}, nil)
+
+	var s = struct {
+		embeddedPrivate
+		Field  int
+		hidden int `etcd:"hidden"`
+		mu     sync.Mutex
+	}{}
+
+	decoder := NewDecoder(etcd)
+	err := decoder.Decode("/path/to/some/struct", &s)
+	assert.Nil(t, err)
+	assert.Equal(t, 10, s.Field)
+	assert.Equal(t, 20, s.Exported)
+	assert.Equal(t, 0, s.hidden)
+}
+
+func TestDecodeDefaultValues(t *testing.T) {
+	etcd := new(test.KeysAPIMock)
+	etcd.On("Get", mock.Anything, "/path/to/some/struct", mock.AnythingOfType("*client.GetOptions")).Return(&client.Response{Node: &client.Node{
+		Key: "/path/to/some/struct",
+		Dir: true,
+		Nodes: []*client.Node{
+			&client.Node{Key: "/path/to/some/struct/retries", Value: "5"},
+		},
+	}}, nil)
+	decoder := NewDecoder(etcd)
+
+	var s = struct {
+		Timeout time.Duration `etcd:"timeout,omitempty" default:"5s"`
+		Retries int           `etcd:"retries" default:"3"`
+		Name    *string       `etcd:"name" default:"unnamed"`
+	}{}
+
+	err := decoder.Decode("/path/to/some/struct", &s)
+	assert.Nil(t, err)
+	assert.Equal(t, 5*time.Second, s.Timeout)
+	assert.Equal(t, 5, s.Retries)
+	assert.Equal(t, "unnamed", *s.Name)
+
+	var invalid = struct {
+		Timeout time.Duration `etcd:"timeout" default:"soon"`
+	}{}
+
+	err = decoder.Decode("/path/to/some/struct", &invalid)
+	assert.Equal(t, `invalid default for /path/to/some/struct/timeout: time: invalid duration "soon"`, err.Error())
+}
+
+type validatedStruct struct {
+	Port    int           `etcd:"port,min=1,max=65535"`
+	Mode    string        `etcd:"mode,oneof=fast safe"`
+	Name    string        `etcd:"name,required,regex=^[a-z]{1,5}$"`
+	Timeout time.Duration `etcd:"timeout,omitempty,max=1m"`
+}
+
+func (s *validatedStruct) Validate() error {
+	if s.Mode == "fast" && s.Port < 1024 {
+		return errors.New("fast mode needs an unprivileged port")
+	}
+	return nil
+}
+
+func TestDecodeValidation(t *testing.T) {
+	cases := []struct {
+		nodes    []*client.Node
+		expected string
+	}{
+		{
+			nodes: []*client.Node{
+				{Key: "/path/to/struct/port", Value: "70000"},
+				{Key: "/path/to/struct/mode", Value: "fast"},
+				{Key: "/path/to/struct/name", Value: "abc"},
+			},
+			expected: "/path/to/struct/port: 70000 is greater than 65535",
+		},
+		{
+			nodes: []*client.Node{
+				{Key: "/path/to/struct/port", Value: "8080"},
+				{Key: "/path/to/struct/mode", Value: "slow"},
+				{Key: "/path/to/struct/name", Value: "abc"},
+			},
+			expected: `/path/to/struct/mode: "slow" is not one of fast, safe`,
+		},
+		{
+			nodes: []*client.Node{
+				{Key: "/path/to/struct/port", Value: "8080"},
+				{Key: "/path/to/struct/mode", Value: "safe"},
+				{Key: "/path/to/struct/name", Value: "abcdef"},
+			},
+			expected: `/path/to/struct/name: "abcdef" does not match ^[a-z]{1,5}$`,
+		},
+		{
+			nodes: []*client.Node{
+				{Key: "/path/to/struct/port", Value: "8080"},
+				{Key: "/path/to/struct/mode", Value: "safe"},
+			},
+			expected: "/path/to/struct/name: key is required",
+		},
+		{
+			nodes: []*client.Node{
+				{Key: "/path/to/struct/port", Value: "8080"},
+				{Key: "/path/to/struct/mode", Value: "safe"},
+				{Key: "/path/to/struct/name", Value: ""},
+			},
+			expected: "/path/to/struct/name: value is required",
+		},
+		{
+			nodes: []*client.Node{
+				{Key: "/path/to/struct/port", Value: "8080"},
+				{Key: "/path/to/struct/mode", Value: "safe"},
+				{Key: "/path/to/struct/name", Value: "abc"},
+				{Key: "/path/to/struct/timeout", Value: "5m"},
+			},
+			expected: "/path/to/struct/timeout: 5m0s is greater than 1m",
+		},
+		{
+			nodes: []*client.Node{
+				{Key: "/path/to/struct/port", Value: "80"},
+				{Key: "/path/to/struct/mode", Value: "fast"},
+				{Key: "/path/to/struct/name", Value: "abc"},
+			},
+			expected: "/path/to/struct: fast mode needs an unprivileged port",
+		},
+	}
+
+	for _, c := range cases {
+		etcd := new(test.KeysAPIMock)
+		etcd.On("Get", mock.Anything, "/path/to/struct", mock.AnythingOfType("*client.GetOptions")).Return(&client.Response{Node: &client.Node{
+			Key:   "/path/to/struct",
+			Dir:   true,
+			Nodes: c.nodes,
+		}}, nil)
+
+		var s validatedStruct
+		decoder := NewDecoder(etcd)
+		err := decoder.Decode("/path/to/struct", &s)
+		assert.IsType(t, &ValidationError{}, err)
+		assert.EqualError(t, err, c.expected)
+	}
+}
+
+type derivedAddress struct {
+	Host    string
+	Port    int
+	Address string `etcd:"-"`
+	Path    string `etcd:"-"`
+}
+
+func (a *derivedAddress) AfterDecode(ctx context.Context) error {
+	a.Address = fmt.Sprintf("%s:%d", a.Host, a.Port)
+	a.Path, _ = PathFromContext(ctx)
+	return nil
+}
+
+func TestDecodeAfterDecodeHook(t *testing.T) {
+	etcd := new(test.KeysAPIMock)
+	etcd.On("Get", mock.Anything, "/path/to/address", mock.AnythingOfType("*client.GetOptions")).Return(&client.Response{Node: &client.Node{
+		Key: "/path/to/address",
+		Dir: true,
+		Nodes: []*client.Node{
+			&client.Node{Key: "/path/to/address/Host", Value: "localhost"},
+			&client.Node{Key: "/path/to/address/Port", Value: "2379"},
+		},
+	}}, nil)
+
+	var a derivedAddress
+	decoder := NewDecoder(etcd)
+	err := decoder.Decode("/path/to/address", &a)
+	assert.Nil(t, err)
+	assert.Equal(t, "localhost:2379", a.Address)
+	assert.Equal(t, "/path/to/address", a.Path)
+}
+
+func (c *certificateBundle) UnmarshalEtcd(node *client.Node) error {
+	for _, n := range node.Nodes {
+		switch n.Key {
+		case node.Key + "/cert":
+			c.Cert = n.Value
+		case node.Key + "/key":
+			c.Key = n.Value
+		case node.Key + "/ca":
+			c.CA = n.Value
+		}
+	}
+	return nil
+}
+
+func TestDecodeEtcdUnmarshaler(t *testing.T) {
+	etcd := new(test.KeysAPIMock)
+	etcd.On("Get", mock.Anything, "/path/to/struct", &client.GetOptions{}).Return(&client.Response{Node: &client.Node{
+		Key: "/path/to/struct",
+		Dir: true,
+		Nodes: []*client.Node{
+			&client.Node{Key: "/path/to/struct/tls", Dir: true},
+		},
+	}}, nil)
+	etcd.On("Get", mock.Anything, "/path/to/struct/tls", &client.GetOptions{}).Return(&client.Response{Node: &client.Node{
+		Key: "/path/to/struct/tls",
+		Dir: true,
+	}}, nil)
+	etcd.On("Get", mock.Anything, "/path/to/struct/tls", &client.GetOptions{Recursive: true, Sort: true}).Return(&client.Response{Node: &client.Node{
+		Key: "/path/to/struct/tls",
+		Dir: true,
+		Nodes: []*client.Node{
+			&client.Node{Key: "/path/to/struct/tls/ca", Value: "CA"},
+			&client.Node{Key: "/path/to/struct/tls/cert", Value: "CERT"},
+			&client.Node{Key: "/path/to/struct/tls/key", Value: "KEY"},
+		},
+	}}, nil)
+
+	var s = struct {
+		TLS *certificateBundle `etcd:"tls"`
+	}{}
+
+	decoder := NewDecoder(etcd)
+	err := decoder.Decode("/path/to/struct", &s)
+	assert.Nil(t, err)
+	assert.Equal(t, &certificateBundle{Cert: "CERT", Key: "KEY", CA: "CA"}, s.TLS)
+}
+
+func TestDecodeRegisteredCodec(t *testing.T) {
+	etcd := new(test.KeysAPIMock)
+	etcd.On("Get", mock.Anything, "/path/to/struct", mock.AnythingOfType("*client.GetOptions")).Return(&client.Response{Node: &client.Node{
+		Key: "/path/to/struct",
+		Dir: true,
+		Nodes: []*client.Node{
+			&client.Node{Key: "/path/to/struct/Point", Value: "1;2"},
+			&client.Node{Key: "/path/to/struct/Shape", Value: "3"},
+		},
+	}}, nil)
+
+	decoder := NewDecoder(etcd)
+	decoder.RegisterCodec(reflect.TypeOf(point{}), nil, func(s string, v reflect.Value) error {
+		var p point
+		if _, err := fmt.Sscanf(s, "%d;%d", &p.X, &p.Y); err != nil {
+			return err
+		}
+		v.Set(reflect.ValueOf(p))
+		return nil
+	})
+	decoder.RegisterCodec(reflect.TypeOf(new(shape)).Elem(), nil, func(s string, v reflect.Value) error {
+		n, err := strconv.Atoi(s)
+		if err != nil {
+			return err
+		}
+		v.Set(reflect.ValueOf(square(n)))
+		return nil
+	})
+
+	var s = struct {
+		Point *point
+		Shape shape
+	}{}
+
+	err := decoder.Decode("/path/to/struct", &s)
+	assert.Nil(t, err)
+	assert.Equal(t, &point{X: 1, Y: 2}, s.Point)
+	assert.Equal(t, square(3), s.Shape)
+}
+
+func TestDecodeTime(t *testing.T) {
+	etcd := new(test.KeysAPIMock)
+	etcd.On("Get", mock.Anything, "/path/to/struct", mock.AnythingOfType("*client.GetOptions")).Return(&client.Response{Node: &client.Node{
+		Key: "/path/to/struct",
+		Dir: true,
+		Nodes: []*client.Node{
+			&client.Node{Key: "/path/to/struct/default", Value: "2020-07-28T21:41:10.0000005Z"},
+			&client.Node{Key: "/path/to/struct/legacy", Value: `"2020-07-28T21:41:10Z"`},
+			&client.Node{Key: "/path/to/struct/date", Value: "2020-07-28"},
+			&client.Node{Key: "/path/to/struct/unix", Value: "1595972470"},
+			&client.Node{Key: "/path/to/struct/unixms", Value: "1595972470500"},
+		},
+	}}, nil)
+
+	var s = struct {
+		Default time.Time  `etcd:"default"`
+		Legacy  time.Time  `etcd:"legacy"`
+		Date    time.Time  `etcd:"date,layout=2006-01-02"`
+		Unix    *time.Time `etcd:"unix,layout=unix"`
+		UnixMs  time.Time  `etcd:"unixms,layout=unixms"`
+	}{}
+
+	decoder := NewDecoder(etcd)
+	err := decoder.Decode("/path/to/struct", &s)
+	assert.Nil(t, err)
+	assert.True(t, time.Date(2020, 7, 28, 21, 41, 10, 500, time.UTC).Equal(s.Default))
+	assert.True(t, time.Date(2020, 7, 28, 21, 41, 10, 0, time.UTC).Equal(s.Legacy))
+	assert.True(t, time.Date(2020, 7, 28, 0, 0, 0, 0, time.UTC).Equal(s.Date))
+	assert.True(t, time.Date(2020, 7, 28, 21, 41, 10, 0, time.UTC).Equal(*s.Unix))
+	assert.True(t, time.Date(2020, 7, 28, 21, 41, 10, 500000000, time.UTC).Equal(s.UnixMs))
+}
+
+func TestDecodeBytes(t *testing.T) {
+	etcd := new(test.KeysAPIMock)
+	etcd.On("Get", mock.Anything, "/path/to/struct", mock.AnythingOfType("*client.GetOptions")).Return(&client.Response{Node: &client.Node{
+		Key: "/path/to/struct",
+		Dir: true,
+		Nodes: []*client.Node{
+			&client.Node{Key: "/path/to/struct/base64", Value: "aGVsbG8="},
+			&client.Node{Key: "/path/to/struct/hex", Value: "0a0b"},
+			&client.Node{Key: "/path/to/struct/raw", Value: "-----BEGIN-----"},
+			&client.Node{Key: "/path/to/struct/legacy", Dir: true},
+		},
+	}}, nil)
+	etcd.On("Get", mock.Anything, "/path/to/struct/legacy", mock.AnythingOfType("*client.GetOptions")).Return(&client.Response{Node: &client.Node{
+		Key: "/path/to/struct/legacy",
+		Dir: true,
+		Nodes: []*client.Node{
+			&client.Node{Key: "/path/to/struct/legacy/0", Value: "104"},
+			&client.Node{Key: "/path/to/struct/legacy/1", Value: "105"},
+		},
+	}}, nil)
+
+	var s = struct {
+		Base64 []byte  `etcd:"base64"`
+		Hex    [2]byte `etcd:"hex,encoding=hex"`
+		Raw    []byte  `etcd:"raw,encoding=raw"`
+		Legacy []byte  `etcd:"legacy"`
+	}{}
+
+	decoder := NewDecoder(etcd)
+	err := decoder.Decode("/path/to/struct", &s)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("hello"), s.Base64)
+	assert.Equal(t, [2]byte{10, 11}, s.Hex)
+	assert.Equal(t, []byte("-----BEGIN-----"), s.Raw)
+	assert.Equal(t, []byte("hi"), s.Legacy)
+
+	var invalid = struct {
+		Hex [3]byte `etcd:"hex,encoding=hex"`
+	}{}
+	err = decoder.Decode("/path/to/struct", &invalid)
+	assert.Equal(t, "can't decode /path/to/struct/hex: can't decode 2 bytes into [3]uint8", err.Error())
+}
+
+func TestDecodeJSONOption(t *testing.T) {
+	etcd := new(test.KeysAPIMock)
+	etcd.On("Get", mock.Anything, "/path/to/struct", mock.AnythingOfType("*client.GetOptions")).Return(&client.Response{Node: &client.Node{
+		Key: "/path/to/struct",
+		Dir: true,
+		Nodes: []*client.Node{
+			&client.Node{Key: "/path/to/struct/blob", Value: `{"Name":"a","Port":80}`},
+			&client.Node{Key: "/path/to/struct/tree", Dir: true},
+		},
+	}}, nil)
+	etcd.On("Get", mock.Anything, "/path/to/struct/tree", mock.AnythingOfType("*client.GetOptions")).Return(&client.Response{Node: &client.Node{
+		Key: "/path/to/struct/tree",
+		Dir: true,
+		Nodes: []*client.Node{
+			&client.Node{Key: "/path/to/struct/tree/Name", Value: "b"},
+			&client.Node{Key: "/path/to/struct/tree/Port", Value: "81"},
+		},
+	}}, nil)
+
+	var s = struct {
+		Blob keyedBackend `etcd:"blob,json"`
+		Tree keyedBackend `etcd:"tree,json"`
+	}{}
+
+	decoder := NewDecoder(etcd)
+	err := decoder.Decode("/path/to/struct", &s)
+	assert.Nil(t, err)
+	assert.Equal(t, keyedBackend{Name: "a", Port: 80}, s.Blob)
+	assert.Equal(t, keyedBackend{Name: "b", Port: 81}, s.Tree)
+}
+
+func TestDecodeTypedInterface(t *testing.T) {
+	etcd := new(test.KeysAPIMock)
+	etcd.On("Get", mock.Anything, "/path/to/struct", mock.AnythingOfType("*client.GetOptions")).Return(&client.Response{Node: &client.Node{
+		Key: "/path/to/struct",
+		Dir: true,
+		Nodes: []*client.Node{
+			&client.Node{Key: "/path/to/struct/backend", Dir: true},
+		},
+	}}, nil)
+	etcd.On("Get", mock.Anything, "/path/to/struct/backend", mock.AnythingOfType("*client.GetOptions")).Return(&client.Response{Node: &client.Node{
+		Key: "/path/to/struct/backend",
+		Dir: true,
+		Nodes: []*client.Node{
+			&client.Node{Key: "/path/to/struct/backend/kind", Value: "local"},
+			&client.Node{Key: "/path/to/struct/backend/Dir", Value: "/tmp"},
+		},
+	}}, nil)
+
+	var s = struct {
+		Backend interface{} `etcd:"backend,typekey=kind"`
+	}{}
+
+	registry := NewRegistry()
+	registry.RegisterType("s3", &s3Backend{})
+	registry.RegisterType("local", localBackend{})
+
+	decoder := NewDecoder(etcd, WithRegistry(registry))
+	err := decoder.Decode("/path/to/struct", &s)
+	assert.Nil(t, err)
+	assert.Equal(t, localBackend{Dir: "/tmp"}, s.Backend)
+
+	var unregistered = struct {
+		Backend interface{} `etcd:"backend,typekey=kind"`
+	}{}
+	err = NewDecoder(etcd).Decode("/path/to/struct", &unregistered)
+	assert.Equal(t, `can't decode /path/to/struct/backend: type "local" is not registered`, err.Error())
+}
+
+func TestDecodeOverflow(t *testing.T) {
+	etcd := new(test.KeysAPIMock)
+	etcd.On("Get", mock.Anything, "/path/to/some/value", mock.AnythingOfType("*client.GetOptions")).Return(&client.Response{Node: &client.Node{Key: "/path/to/some/value", Value: "300"}}, nil)
+	etcd.On("Get", mock.Anything, "/path/to/some/float", mock.AnythingOfType("*client.GetOptions")).Return(&client.Response{Node: &client.Node{Key: "/path/to/some/float", Value: "1e300"}}, nil)
+	decoder := NewDecoder(etcd)
+
+	var a int8
+	err := decoder.Decode("/path/to/some/value", &a)
+	assert.Equal(t, &RangeError{Key: "/path/to/some/value", Value: "300", Type: reflect.TypeOf(a)}, err)
+	assert.Equal(t, "/path/to/some/value: value 300 is out of range for int8", err.Error())
+
+	var b uint8
+	err = decoder.Decode("/path/to/some/value", &b)
+	assert.IsType(t, &RangeError{}, err)
+
+	var c int16
+	err = decoder.Decode("/path/to/some/value", &c)
+	assert.Nil(t, err)
+	assert.Equal(t, int16(300), c)
+
+	var d float32
+	err = decoder.Decode("/path/to/some/float", &d)
+	assert.Equal(t, "/path/to/some/float: value 1e300 is out of range for float32", err.Error())
+
+	var e float64
+	err = decoder.Decode("/path/to/some/float", &e)
+	assert.Nil(t, err)
+	assert.Equal(t, 1e300, e)
+}
+
+func TestDecodeStdlibScalars(t *testing.T) {
+	etcd := new(test.KeysAPIMock)
+	etcd.On("Get", mock.Anything, "/path/to/struct", mock.AnythingOfType("*client.GetOptions")).Return(&client.Response{Node: &client.Node{
+		Key: "/path/to/struct",
+		Dir: true,
+		Nodes: []*client.Node{
+			&client.Node{Key: "/path/to/struct/int", Value: "123456789012345678901234567890"},
+			&client.Node{Key: "/path/to/struct/float", Value: "1.5"},
+			&client.Node{Key: "/path/to/struct/complex", Value: "(1.5-2e-3i)"},
+			&client.Node{Key: "/path/to/struct/ip", Value: "::1"},
+			&client.Node{Key: "/path/to/struct/cidr", Value: "10.1.2.3/8"},
+			&client.Node{Key: "/path/to/struct/url", Value: "https://example.com/path?q=1"},
+			&client.Node{Key: "/path/to/struct/regexp", Value: "^a+b$"},
+		},
+	}}, nil)
+
+	var s = struct {
+		Int     *big.Int       `etcd:"int"`
+		Float   big.Float      `etcd:"float"`
+		Complex complex128     `etcd:"complex"`
+		IP      net.IP         `etcd:"ip"`
+		CIDR    net.IPNet      `etcd:"cidr"`
+		URL     *url.URL       `etcd:"url"`
+		Regexp  *regexp.Regexp `etcd:"regexp"`
+	}{}
+
+	decoder := NewDecoder(etcd)
+	err := decoder.Decode("/path/to/struct", &s)
+	assert.Nil(t, err)
+	assert.Equal(t, "123456789012345678901234567890", s.Int.String())
+	assert.Equal(t, "1.5", s.Float.Text('g', -1))
+	assert.Equal(t, complex(1.5, -0.002), s.Complex)
+	assert.True(t, net.IPv6loopback.Equal(s.IP))
+	assert.Equal(t, "10.0.0.0/8", s.CIDR.String())
+	assert.Equal(t, "example.com", s.URL.Host)
+	assert.True(t, s.Regexp.MatchString("aab"))
+}
+
+func TestDecodeInvalidStdlibScalars(t *testing.T) {
+	etcd := new(test.KeysAPIMock)
+	etcd.On("Get", mock.Anything, "/path/to/value", mock.AnythingOfType("*client.GetOptions")).Return(&client.Response{Node: &client.Node{
+		Key:   "/path/to/value",
+		Value: "10.0.0.256",
+	}}, nil)
+	decoder := NewDecoder(etcd)
+
+	var ip net.IP
+	err := decoder.Decode("/path/to/value", &ip)
+	assert.Equal(t, `can't decode /path/to/value: invalid IP address "10.0.0.256"`, err.Error())
+
+	var c complex64
+	err = decoder.Decode("/path/to/value", &c)
+	assert.Equal(t, `invalid complex number "10.0.0.256"`, err.Error())
+}
+
+func TestDecodeUnits(t *testing.T) {
+	etcd := new(test.KeysAPIMock)
+	etcd.On("Get", mock.Anything, "/path/to/struct", mock.AnythingOfType("*client.GetOptions")).Return(&client.Response{Node: &client.Node{
+		Key: "/path/to/struct",
+		Dir: true,
+		Nodes: []*client.Node{
+			&client.Node{Key: "/path/to/struct/max_body", Value: "1.5GiB"},
+			&client.Node{Key: "/path/to/struct/buffer", Value: "512MB"},
+			&client.Node{Key: "/path/to/struct/plain", Value: "4096"},
+			&client.Node{Key: "/path/to/struct/timeout_ms", Value: "1m30s"},
+			&client.Node{Key: "/path/to/struct/retention", Value: "1d12h"},
+			&client.Node{Key: "/path/to/struct/ttl", Value: "90d"},
+		},
+	}}, nil)
+
+	var s = struct {
+		MaxBody   int64         `etcd:"max_body,unit=bytes"`
+		Buffer    uint32        `etcd:"buffer,unit=bytes"`
+		Plain     int           `etcd:"plain,unit=bytes"`
+		TimeoutMs int           `etcd:"timeout_ms,unit=ms"`
+		Retention int           `etcd:"retention,unit=h"`
+		TTL       time.Duration `etcd:"ttl"`
+	}{}
+
+	decoder := NewDecoder(etcd)
+	err := decoder.Decode("/path/to/struct", &s)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1536<<20), s.MaxBody)
+	assert.Equal(t, uint32(512000000), s.Buffer)
+	assert.Equal(t, 4096, s.Plain)
+	assert.Equal(t, 90000, s.TimeoutMs)
+	assert.Equal(t, 36, s.Retention)
+	assert.Equal(t, 90*24*time.Hour, s.TTL)
+}
+
+func TestDecodeInvalidUnits(t *testing.T) {
+	etcd := new(test.KeysAPIMock)
+	etcd.On("Get", mock.Anything, "/path/to/struct", mock.AnythingOfType("*client.GetOptions")).Return(&client.Response{Node: &client.Node{
+		Key: "/path/to/struct",
+		Dir: true,
+		Nodes: []*client.Node{
+			&client.Node{Key: "/path/to/struct/size", Value: "10XB"},
+			&client.Node{Key: "/path/to/struct/small", Value: "1KiB"},
+			&client.Node{Key: "/path/to/struct/timeout", Value: "1500us"},
+		},
+	}}, nil)
+	decoder := NewDecoder(etcd)
+
+	var size struct {
+		Size int `etcd:"size,unit=bytes"`
+	}
+	err := decoder.Decode("/path/to/struct", &size)
+	assert.Equal(t, `can't decode /path/to/struct/size: invalid size "10XB"`, err.Error())
+
+	var small struct {
+		Small uint8 `etcd:"small,unit=bytes"`
+	}
+	err = decoder.Decode("/path/to/struct", &small)
+	assert.Equal(t, "/path/to/struct/small: value 1KiB is out of range for uint8", err.Error())
+
+	var timeout struct {
+		Timeout int `etcd:"timeout,unit=ms"`
+	}
+	err = decoder.Decode("/path/to/struct", &timeout)
+	assert.Equal(t, `can't decode /path/to/struct/timeout: "1500us" is not a whole number of ms`, err.Error())
+}
+
+func TestDecodeWeaklyTypedInput(t *testing.T) {
+	etcd := new(test.KeysAPIMock)
+	etcd.On("Get", mock.Anything, "/path/to/struct", mock.AnythingOfType("*client.GetOptions")).Return(&client.Response{Node: &client.Node{
+		Key: "/path/to/struct",
+		Dir: true,
+		Nodes: []*client.Node{
+			&client.Node{Key: "/path/to/struct/enabled", Value: "Yes"},
+			&client.Node{Key: "/path/to/struct/debug", Value: "off"},
+			&client.Node{Key: "/path/to/struct/port", Value: "8080.0"},
+			&client.Node{Key: "/path/to/struct/workers", Value: ""},
+			&client.Node{Key: "/path/to/struct/ratio", Value: " "},
+			&client.Node{Key: "/path/to/struct/hosts", Value: "example.com"},
+			&client.Node{Key: "/path/to/struct/ports", Value: "1e3"},
+		},
+	}}, nil)
+
+	var s = struct {
+		Enabled bool     `etcd:"enabled"`
+		Debug   bool     `etcd:"debug"`
+		Port    uint16   `etcd:"port"`
+		Workers int      `etcd:"workers"`
+		Ratio   float64  `etcd:"ratio"`
+		Hosts   []string `etcd:"hosts"`
+		Ports   []int    `etcd:"ports"`
+	}{Debug: true, Workers: 4, Ratio: 0.5}
+
+	decoder := NewDecoder(etcd, WithWeaklyTypedInput())
+	err := decoder.Decode("/path/to/struct", &s)
+	assert.Nil(t, err)
+	assert.True(t, s.Enabled)
+	assert.False(t, s.Debug)
+	assert.Equal(t, uint16(8080), s.Port)
+	assert.Equal(t, 0, s.Workers)
+	assert.Equal(t, 0.0, s.Ratio)
+	assert.Equal(t, []string{"example.com"}, s.Hosts)
+	assert.Equal(t, []int{1000}, s.Ports)
+}
+
+func TestDecodeWeaklyTypedInputErrors(t *testing.T) {
+	etcd := new(test.KeysAPIMock)
+	etcd.On("Get", mock.Anything, "/path/to/value", mock.AnythingOfType("*client.GetOptions")).Return(&client.Response{Node: &client.Node{
+		Key:   "/path/to/value",
+		Value: "1.5",
+	}}, nil)
+
+	var i int
+	err := NewDecoder(etcd, WithWeaklyTypedInput()).Decode("/path/to/value", &i)
+	assert.NotNil(t, err)
+
+	var f float32
+	err = NewDecoder(etcd, WithWeaklyTypedInput()).Decode("/path/to/value", &f)
+	assert.Nil(t, err)
+	assert.Equal(t, float32(1.5), f)
+}
+
+func TestDecodeEnum(t *testing.T) {
+	etcd := new(test.KeysAPIMock)
+	etcd.On("Get", mock.Anything, "/path/to/struct", mock.AnythingOfType("*client.GetOptions")).Return(&client.Response{Node: &client.Node{
+		Key: "/path/to/struct",
+		Dir: true,
+		Nodes: []*client.Node{
+			&client.Node{Key: "/path/to/struct/mode", Value: "standby"},
+			&client.Node{Key: "/path/to/struct/level", Value: "info"},
+		},
+	}}, nil)
+
+	var s struct {
+		Mode  mode  `etcd:"mode"`
+		Level level `etcd:"level"`
+	}
+
+	decoder := NewDecoder(etcd)
+	err := decoder.RegisterEnum(map[string]mode{"active": modeActive, "standby": modeStandby})
+	assert.Nil(t, err)
+	err = decoder.Decode("/path/to/struct", &s)
+	assert.Nil(t, err)
+	assert.Equal(t, modeStandby, s.Mode)
+	assert.Equal(t, level(1), s.Level)
+}
+
+func TestDecodeInvalidEnum(t *testing.T) {
+	etcd := new(test.KeysAPIMock)
+	etcd.On("Get", mock.Anything, "/path/to/level", mock.AnythingOfType("*client.GetOptions")).Return(&client.Response{Node: &client.Node{
+		Key:   "/path/to/level",
+		Value: "2",
+	}}, nil)
+	etcd.On("Get", mock.Anything, "/path/to/unknown", mock.AnythingOfType("*client.GetOptions")).Return(&client.Response{Node: &client.Node{
+		Key:   "/path/to/unknown",
+		Value: "7",
+	}}, nil)
+
+	var l level
+	err := NewDecoder(etcd).Decode("/path/to/level", &l)
+	assert.Equal(t, `can't decode /path/to/level: invalid value "2" for etcd.level, valid values are: debug, error, info`, err.Error())
+
+	decoder := NewDecoder(etcd, WithNumericEnums())
+	err = decoder.Decode("/path/to/level", &l)
+	assert.Nil(t, err)
+	assert.Equal(t, level(2), l)
+
+	err = decoder.Decode("/path/to/unknown", &l)
+	assert.NotNil(t, err)
+}
+
+func TestDecodeWithJSONTags(t *testing.T) {
+	etcd := new(test.KeysAPIMock)
+	etcd.On("Get", mock.Anything, "/path/to/struct", mock.AnythingOfType("*client.GetOptions")).Return(&client.Response{Node: &client.Node{
+		Key: "/path/to/struct",
+		Dir: true,
+		Nodes: []*client.Node{
+			&client.Node{Key: "/path/to/struct/name", Value: "api"},
+			&client.Node{Key: "/path/to/struct/Secret", Value: "secret"},
+			&client.Node{Key: "/path/to/struct/etcd_name", Value: "etcd"},
+			&client.Node{Key: "/path/to/struct/Plain", Value: "plain"},
+		},
+	}}, nil)
+	etcd.On("Get", mock.Anything, "/path/to/struct/port", mock.AnythingOfType("*client.GetOptions")).Return(nil, client.Error{Code: client.ErrorCodeKeyNotFound})
+
+	var s jsonTagged
+	decoder := NewDecoder(etcd, WithJSONTags())
+	err := decoder.Decode("/path/to/struct", &s)
+	assert.Nil(t, err)
+	assert.Equal(t, jsonTagged{Name: "api", Override: "etcd", Plain: "plain"}, s)
+}
+
+func TestDecodeWithNaming(t *testing.T) {
+	etcd := new(test.KeysAPIMock)
+	etcd.On("Get", mock.Anything, "/path/to/struct", mock.AnythingOfType("*client.GetOptions")).Return(&client.Response{Node: &client.Node{
+		Key: "/path/to/struct",
+		Dir: true,
+		Nodes: []*client.Node{
+			&client.Node{Key: "/path/to/struct/max-conns", Value: "10"},
+			&client.Node{Key: "/path/to/struct/Tagged", Value: "tagged"},
+			&client.Node{Key: "/path/to/struct/IdleTimeout", Value: "1m"},
+		},
+	}}, nil)
+
+	var s struct {
+		MaxConns    int
+		Field       string        `etcd:"Tagged"`
+		IdleTimeout time.Duration `etcd:"idletimeout"`
+	}
+
+	err := NewDecoder(etcd, WithNaming(KebabCase)).Decode("/path/to/struct", &s)
+	assert.NotNil(t, err)
+
+	err = NewDecoder(etcd, WithNaming(KebabCase), WithCaseInsensitiveKeys()).Decode("/path/to/struct", &s)
+	assert.Nil(t, err)
+	assert.Equal(t, 10, s.MaxConns)
+	assert.Equal(t, "tagged", s.Field)
+	assert.Equal(t, time.Minute, s.IdleTimeout)
+}
+
+func TestDecodeAliases(t *testing.T) {
+	etcd := new(test.KeysAPIMock)
+	etcd.On("Get", mock.Anything, "/path/to/old", mock.AnythingOfType("*client.GetOptions")).Return(&client.Response{Node: &client.Node{
+		Key: "/path/to/old",
+		Dir: true,
+		Nodes: []*client.Node{
+			&client.Node{Key: "/path/to/old/MaxConns", Value: "5"},
+		},
+	}}, nil)
+	etcd.On("Get", mock.Anything, "/path/to/both", mock.AnythingOfType("*client.GetOptions")).Return(&client.Response{Node: &client.Node{
+		Key: "/path/to/both",
+		Dir: true,
+		Nodes: []*client.Node{
+			&client.Node{Key: "/path/to/both/MaxConns", Value: "5"},
+			&client.Node{Key: "/path/to/both/max_conns", Value: "10"},
+		},
+	}}, nil)
+
+	decoder := NewDecoder(etcd)
+
+	var s aliased
+	err := decoder.Decode("/path/to/old", &s)
+	assert.Nil(t, err)
+	assert.Equal(t, 5, s.MaxConns)
+
+	err = decoder.Decode("/path/to/both", &s)
+	assert.Nil(t, err)
+	assert.Equal(t, 10, s.MaxConns)
+}
+
+func TestUnitsLimitsRoundTrip(t *testing.T) {
+	var s = struct {
+		Max     int64  `etcd:"max,unit=bytes"`
+		Min     int64  `etcd:"min,unit=bytes"`
+		MaxUint uint64 `etcd:"max_uint,unit=bytes"`
+		Odd     int64  `etcd:"odd,unit=ms"`
+	}{math.MaxInt64, math.MinInt64, math.MaxUint64, 9007199254740993}
+
+	etcd := new(test.KeysAPIMock)
+	var nodes []*client.Node
+	etcd.On("Set", mock.Anything, mock.AnythingOfType("string"), mock.AnythingOfType("string"), mock.AnythingOfType("*client.SetOptions")).Return(&client.Response{}, nil).Run(func(args mock.Arguments) {
+		nodes = append(nodes, &client.Node{Key: args.String(1), Value: args.String(2)})
+	})
+	err := NewEncoder(etcd).Encode("/path/to/struct", s)
+	assert.Nil(t, err)
+	assert.Equal(t, "9223372036854775807", nodes[0].Value)
+	assert.Equal(t, "-8EiB", nodes[1].Value)
+
+	etcd.On("Get", mock.Anything, "/path/to/struct", mock.AnythingOfType("*client.GetOptions")).Return(&client.Response{Node: &client.Node{
+		Key:   "/path/to/struct",
+		Dir:   true,
+		Nodes: nodes,
+	}}, nil)
+
+	decoded := s
+	decoded.Max, decoded.Min, decoded.MaxUint, decoded.Odd = 0, 0, 0, 0
+	err = NewDecoder(etcd).Decode("/path/to/struct", &decoded)
+	assert.Nil(t, err)
+	assert.Equal(t, s, decoded)
+}
+
+func TestDecodeUnitsOverflow(t *testing.T) {
+	etcd := new(test.KeysAPIMock)
+	etcd.On("Get", mock.Anything, "/path/to/struct", mock.AnythingOfType("*client.GetOptions")).Return(&client.Response{Node: &client.Node{
+		Key: "/path/to/struct",
+		Dir: true,
+		Nodes: []*client.Node{
+			&client.Node{Key: "/path/to/struct/size", Value: "9223372036854775808"},
+			&client.Node{Key: "/path/to/struct/usize", Value: "16EiB"},
+		},
+	}}, nil)
+
+	var size struct {
+		Size int64 `etcd:"size,unit=bytes"`
+	}
+	err := NewDecoder(etcd).Decode("/path/to/struct", &size)
+	assert.Equal(t, "/path/to/struct/size: value 9223372036854775808 is out of range for int64", err.Error())
+
+	var usize struct {
+		Size uint64 `etcd:"usize,unit=bytes"`
+	}
+	err = NewDecoder(etcd).Decode("/path/to/struct", &usize)
+	assert.Equal(t, "/path/to/struct/usize: value 16EiB is out of range for uint64", err.Error())
+}
+
+func TestDecodeValidationDayBounds(t *testing.T) {
+	etcd := new(test.KeysAPIMock)
+	etcd.On("Get", mock.Anything, "/path/to/struct", mock.AnythingOfType("*client.GetOptions")).Return(&client.Response{Node: &client.Node{
+		Key: "/path/to/struct",
+		Dir: true,
+		Nodes: []*client.Node{
+			&client.Node{Key: "/path/to/struct/ttl", Value: "8d"},
+		},
+	}}, nil)
+
+	var s struct {
+		TTL time.Duration `etcd:"ttl,min=1d,max=7d"`
+	}
+	err := NewDecoder(etcd).Decode("/path/to/struct", &s)
+	assert.Equal(t, "/path/to/struct/ttl: 192h0m0s is greater than 7d", err.Error())
+}
+
+func TestNilTimeRoundTrip(t *testing.T) {
+	type withPointer struct {
+		Name    string
+		Expires *time.Time
+	}
+
+	etcd := new(test.KeysAPIMock)
+	var nodes []*client.Node
+	etcd.On("Set", mock.Anything, mock.AnythingOfType("string"), mock.AnythingOfType("string"), mock.AnythingOfType("*client.SetOptions")).Return(&client.Response{}, nil).Run(func(args mock.Arguments) {
+		nodes = append(nodes, &client.Node{Key: args.String(1), Value: args.String(2)})
+	})
+	err := NewEncoder(etcd).Encode("/path/to/struct", withPointer{Name: "a"})
+	assert.Nil(t, err)
+	assert.Len(t, nodes, 1)
+
+	etcd.On("Get", mock.Anything, "/path/to/struct", mock.AnythingOfType("*client.GetOptions")).Return(&client.Response{Node: &client.Node{
+		Key:   "/path/to/struct",
+		Dir:   true,
+		Nodes: nodes,
+	}}, nil)
+
+	now := time.Now()
+	s := withPointer{Expires: &now}
+	err = NewDecoder(etcd).Decode("/path/to/struct", &s)
+	assert.Nil(t, err)
+	assert.Equal(t, withPointer{Name: "a"}, s)
+
+	var other struct {
+		Name    string
+		Backend *keyedBackend
+	}
+	err = NewDecoder(etcd).Decode("/path/to/struct", &other)
+	assert.Equal(t, "Key /path/to/struct/Backend not found", err.Error())
+}
+
+func TestZeroIPNetRoundTrip(t *testing.T) {
+	type network struct {
+		CIDR net.IPNet `etcd:"cidr"`
+	}
+
+	etcd := new(test.KeysAPIMock)
+	var nodes []*client.Node
+	etcd.On("Set", mock.Anything, mock.AnythingOfType("string"), mock.AnythingOfType("string"), mock.AnythingOfType("*client.SetOptions")).Return(&client.Response{}, nil).Run(func(args mock.Arguments) {
+		nodes = append(nodes, &client.Node{Key: args.String(1), Value: args.String(2)})
+	})
+	err := NewEncoder(etcd).Encode("/path/to/struct", network{})
+	assert.Nil(t, err)
+	assert.Equal(t, "", nodes[0].Value)
+
+	etcd.On("Get", mock.Anything, "/path/to/struct", mock.AnythingOfType("*client.GetOptions")).Return(&client.Response{Node: &client.Node{
+		Key:   "/path/to/struct",
+		Dir:   true,
+		Nodes: nodes,
+	}}, nil)
+
+	_, cidr, _ := net.ParseCIDR("10.0.0.0/8")
+	s := network{CIDR: *cidr}
+	err = NewDecoder(etcd).Decode("/path/to/struct", &s)
+	assert.Nil(t, err)
+	assert.Equal(t, network{}, s)
+}
+
+func TestDecodeValidationLargeBounds(t *testing.T) {
+	etcd := new(test.KeysAPIMock)
+	etcd.On("Get", mock.Anything, "/path/to/struct", mock.AnythingOfType("*client.GetOptions")).Return(&client.Response{Node: &client.Node{
+		Key: "/path/to/struct",
+		Dir: true,
+		Nodes: []*client.Node{
+			&client.Node{Key: "/path/to/struct/signed", Value: "9007199254740993"},
+			&client.Node{Key: "/path/to/struct/unsigned", Value: "18446744073709551615"},
+		},
+	}}, nil)
+
+	var signed struct {
+		Signed int64 `etcd:"signed,max=9007199254740992"`
+	}
+	err := NewDecoder(etcd).Decode("/path/to/struct", &signed)
+	assert.Equal(t, "/path/to/struct/signed: 9007199254740993 is greater than 9007199254740992", err.Error())
+
+	var unsigned struct {
+		Unsigned uint64 `etcd:"unsigned,max=18446744073709551614"`
+	}
+	err = NewDecoder(etcd).Decode("/path/to/struct", &unsigned)
+	assert.Equal(t, "/path/to/struct/unsigned: 18446744073709551615 is greater than 18446744073709551614", err.Error())
+}
+
+func TestDecodeWithJSONDashKey(t *testing.T) {
+	etcd := new(test.KeysAPIMock)
+	etcd.On("Get", mock.Anything, "/path/to/struct", mock.AnythingOfType("*client.GetOptions")).Return(&client.Response{Node: &client.Node{
+		Key: "/path/to/struct",
+		Dir: true,
+		Nodes: []*client.Node{
+			&client.Node{Key: "/path/to/struct/-", Value: "dash"},
+		},
+	}}, nil)
+
+	var s struct {
+		Dash    string `json:"-,"`
+		Skipped string `json:"-"`
+	}
+	err := NewDecoder(etcd, WithJSONTags()).Decode("/path/to/struct", &s)
+	assert.Nil(t, err)
+	assert.Equal(t, "dash", s.Dash)
+}
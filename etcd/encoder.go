@@ -4,29 +4,48 @@ import (
 	"context"
 	"encoding"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
 
 	"go.etcd.io/etcd/v3/client"
 )
 
 var (
+	marshalerType     = reflect.TypeOf(new(Marshaler)).Elem()
 	jsonMarshalerType = reflect.TypeOf(new(json.Marshaler)).Elem()
 	textMarshalerType = reflect.TypeOf(new(encoding.TextMarshaler)).Elem()
 )
 
+// Marshaler is implemented by types that lay out their own subtree.
+// MarshalEtcd returns the values to store keyed by their path relative to the
+// value, e.g. {"cert": ..., "key": ..., "ca": ...}. The empty key stores a
+// value at the path itself.
+type Marshaler interface {
+	MarshalEtcd() (map[string]string, error)
+}
+
 type Encoder interface {
 	Encode(string, interface{}) error
 	EncodeWithContext(string, interface{}, context.Context) error
+	RegisterCodec(reflect.Type, EncodeFunc, DecodeFunc)
+	RegisterType(string, interface{})
+	RegisterEnum(interface{}) error
 }
 
 type encoder struct {
 	client client.KeysAPI
+	options
 }
 
-func NewEncoder(client client.KeysAPI) Encoder {
+func NewEncoder(client client.KeysAPI, opts ...Option) Encoder {
 	return &encoder{
-		client: client,
+		client:  client,
+		options: newOptions(opts),
 	}
 }
 
@@ -35,39 +54,118 @@ func (e *encoder) Encode(path string, v interface{}) error {
 }
 
 func (e *encoder) EncodeWithContext(path string, v interface{}, ctx context.Context) error {
-	return e.encode(path, reflect.ValueOf(v), ctx)
+	return e.encode(path, reflect.ValueOf(v), tagOptions{}, ctx)
+}
+
+func (e *encoder) RegisterCodec(t reflect.Type, encode EncodeFunc, decode DecodeFunc) {
+	e.registry.RegisterCodec(t, encode, decode)
 }
 
-func (e *encoder) indirect(v reflect.Value) (json.Marshaler, encoding.TextMarshaler) {
+func (e *encoder) RegisterType(name string, v interface{}) {
+	e.registry.RegisterType(name, v)
+}
+
+func (e *encoder) RegisterEnum(values interface{}) error {
+	return e.registry.RegisterEnum(values)
+}
+
+func (e *encoder) indirect(v reflect.Value) (Marshaler, json.Marshaler, encoding.TextMarshaler) {
 	t := v.Type()
+	if t.Implements(marshalerType) {
+		return v.Interface().(Marshaler), nil, nil
+	}
+
+	if t.Kind() != reflect.Ptr && v.CanAddr() {
+		if reflect.PtrTo(t).Implements(marshalerType) {
+			return v.Addr().Interface().(Marshaler), nil, nil
+		}
+	}
+
 	if t.Implements(jsonMarshalerType) {
-		return v.Interface().(json.Marshaler), nil
+		return nil, v.Interface().(json.Marshaler), nil
 	}
 
 	if t.Kind() != reflect.Ptr && v.CanAddr() {
 		if reflect.PtrTo(t).Implements(jsonMarshalerType) {
-			return v.Interface().(json.Marshaler), nil
+			return nil, v.Addr().Interface().(json.Marshaler), nil
 		}
 	}
 
 	if t.Implements(textMarshalerType) {
-		return nil, v.Interface().(encoding.TextMarshaler)
+		return nil, nil, v.Interface().(encoding.TextMarshaler)
 	}
 
 	if t.Kind() != reflect.Ptr && v.CanAddr() {
 		if reflect.PtrTo(t).Implements(textMarshalerType) {
 			va := v.Addr()
 			if !va.IsNil() {
-				return nil, v.Interface().(encoding.TextMarshaler)
+				return nil, nil, va.Interface().(encoding.TextMarshaler)
 			}
 		}
 	}
 
-	return nil, nil
+	return nil, nil, nil
 }
 
-func (e *encoder) encode(path string, value reflect.Value, ctx context.Context) error {
-	m, tm := e.indirect(value)
+func (e *encoder) encode(path string, value reflect.Value, tag tagOptions, ctx context.Context) error {
+	if !value.IsValid() {
+		// A nil interface is stored as a missing key, like a nil pointer.
+		e.deleteNode(path, ctx)
+		return nil
+	}
+
+	if tag.has("json") {
+		return e.encodeJSON(path, value, ctx)
+	}
+
+	if typeKey := tag.get("typekey"); typeKey != "" && value.Kind() == reflect.Interface {
+		return e.encodeTyped(path, value, typeKey, ctx)
+	}
+
+	if encode, ok := e.registry.encoder(value.Type()); ok {
+		s, err := encode(value)
+		if err != nil {
+			return err
+		}
+		return e.setNode(path, s, ctx)
+	}
+
+	if en, err := e.registry.enum(value.Type()); err != nil {
+		return fmt.Errorf("%s: %s", path, err)
+	} else if en != nil {
+		s, err := en.encode(value)
+		if err != nil {
+			return fmt.Errorf("%s: %s", path, err)
+		}
+		return e.setNode(path, s, ctx)
+	}
+
+	if value.Kind() == reflect.Ptr {
+		if value.IsNil() {
+			// A nil pointer is stored as a missing key.
+			e.deleteNode(path, ctx)
+			return nil
+		}
+		return e.encode(path, value.Elem(), tag, ctx)
+	}
+
+	if unit := tag.get("unit"); unit != "" {
+		s, err := formatUnit(value, unit)
+		if err != nil {
+			return fmt.Errorf("%s: %s", path, err)
+		}
+		return e.setNode(path, s, ctx)
+	}
+
+	if value.Type() == timeType {
+		return e.setNode(path, formatTime(value.Interface().(time.Time), tag.get("layout")), ctx)
+	}
+
+	em, m, tm := e.indirect(value)
+	if em != nil {
+		return e.encodeEtcdMarshaler(em, path, ctx)
+	}
+
 	if m != nil {
 		return e.encodeMarshaler(m, path, ctx)
 	}
@@ -78,7 +176,7 @@ func (e *encoder) encode(path string, value reflect.Value, ctx context.Context)
 
 	switch value.Kind() {
 	case reflect.Interface:
-		return e.encode(path, value.Elem(), ctx)
+		return e.encode(path, value.Elem(), tag, ctx)
 
 	case reflect.Struct:
 		return e.encodeStruct(path, value, ctx)
@@ -87,17 +185,31 @@ func (e *encoder) encode(path string, value reflect.Value, ctx context.Context)
 		e.deleteNode(path, ctx)
 		return e.encodeMap(path, value, ctx)
 
-	case reflect.Slice:
+	case reflect.Slice, reflect.Array:
+		if isBytes(value.Type()) {
+			s, err := bytesToString(value, tag.get("encoding"))
+			if err != nil {
+				return fmt.Errorf("can't encode %s: %s", path, err)
+			}
+			return e.setNode(path, s, ctx)
+		}
+		if value.Kind() == reflect.Array {
+			e.deleteNode(path, ctx)
+			return e.encodeSlice(path, value, ctx)
+		}
+		if key := tag.get("key"); key != "" {
+			return e.encodeKeyedSlice(path, value, key, ctx)
+		}
+		if tag.has("inorder") {
+			return e.encodeInOrderSlice(path, value, ctx)
+		}
 		e.deleteNode(path, ctx)
 		return e.encodeSlice(path, value, ctx)
 
-	case reflect.Ptr:
-		return e.encode(path, value.Elem(), ctx)
-
 	default:
 		s, err := valueToString(value)
 		if err != nil {
-			return err
+			return fmt.Errorf("%s: %s", path, err)
 		}
 
 		if err := e.setNode(path, s, ctx); err != nil {
@@ -108,6 +220,78 @@ func (e *encoder) encode(path string, value reflect.Value, ctx context.Context)
 	return nil
 }
 
+// encodeJSON stores a field tagged with the "json" option as a single JSON
+// value. A dir left under path by the tree layout is replaced.
+func (e *encoder) encodeJSON(path string, value reflect.Value, ctx context.Context) error {
+	b, err := json.Marshal(value.Interface())
+	if err != nil {
+		return err
+	}
+
+	err = e.setNode(path, string(b), ctx)
+	if ce, ok := err.(client.Error); ok && ce.Code == client.ErrorCodeNotFile {
+		e.deleteNode(path, ctx)
+		err = e.setNode(path, string(b), ctx)
+	}
+	return err
+}
+
+// encodeTyped stores the struct held by an interface field tagged with the
+// "typekey" option, together with the registered name of its type.
+func (e *encoder) encodeTyped(path string, value reflect.Value, typeKey string, ctx context.Context) error {
+	e.deleteNode(path, ctx)
+	if value.IsNil() {
+		return nil
+	}
+
+	value = value.Elem()
+	name, ok := e.registry.typeName(value.Type())
+	if !ok {
+		return fmt.Errorf("can't encode %s: type %s is not registered", path, value.Type())
+	}
+
+	if value.Kind() == reflect.Ptr {
+		if value.IsNil() {
+			return nil
+		}
+		value = value.Elem()
+	}
+	if value.Kind() != reflect.Struct {
+		return fmt.Errorf("can't encode %s: typekey requires a struct, got %s", path, value.Type())
+	}
+
+	if err := e.setNode(fmt.Sprintf("%s/%s", path, typeKey), name, ctx); err != nil {
+		return err
+	}
+	return e.encodeStruct(path, value, ctx)
+}
+
+func (e *encoder) encodeEtcdMarshaler(u Marshaler, path string, ctx context.Context) error {
+	values, err := u.MarshalEtcd()
+	if err != nil {
+		return err
+	}
+
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	e.deleteNode(path, ctx)
+	for _, key := range keys {
+		keyPath := path
+		if key != "" {
+			keyPath = fmt.Sprintf("%s/%s", path, strings.Trim(key, "/"))
+		}
+		if err := e.setNode(keyPath, values[key], ctx); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
 func (e *encoder) encodeMarshaler(u json.Marshaler, path string, ctx context.Context) error {
 	s, err := u.MarshalJSON()
 	if err != nil {
@@ -127,14 +311,44 @@ func (e *encoder) encodeTextMarshaler(u encoding.TextMarshaler, path string, ctx
 }
 
 func (e *encoder) encodeStruct(path string, value reflect.Value, ctx context.Context) error {
-	for i := 0; i < value.NumField(); i++ {
-		typeField := value.Type().Field(i)
-		name := typeField.Tag.Get("etcd")
-		if name != "-" {
-			if name == "" {
-				name = typeField.Name
+	value, err := beforeEncode(path, value, ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, f := range typeFields(value.Type(), e.options) {
+		fieldValue, ok := fieldByIndex(value, f.index)
+		if !ok {
+			continue
+		}
+
+		fieldPath := fmt.Sprintf("%s/%s", path, f.name)
+		paths := []string{fieldPath}
+		for _, alias := range strings.Fields(f.tag.get("alias")) {
+			aliasPath := fmt.Sprintf("%s/%s", path, alias)
+			switch e.aliases {
+			case AliasWrite:
+				paths = append(paths, aliasPath)
+			case AliasDelete:
+				e.deleteNode(aliasPath, ctx)
 			}
-			if err := e.encode(fmt.Sprintf("%s/%s", path, name), value.Field(i), ctx); err != nil {
+		}
+
+		if e.omitDefaults && f.hasDefault {
+			isDefault, err := e.isDefault(fieldPath, fieldValue, f, ctx)
+			if err != nil {
+				return err
+			}
+			if isDefault {
+				for _, p := range paths {
+					e.deleteNode(p, ctx)
+				}
+				continue
+			}
+		}
+
+		for _, p := range paths {
+			if err := e.encode(p, fieldValue, f.tag, ctx); err != nil {
 				return err
 			}
 		}
@@ -143,14 +357,28 @@ func (e *encoder) encodeStruct(path string, value reflect.Value, ctx context.Con
 	return nil
 }
 
+// isDefault reports whether value equals the default of field f.
+func (e *encoder) isDefault(path string, value reflect.Value, f field, ctx context.Context) (bool, error) {
+	d := &decoder{options: e.options}
+	defaultValue := reflect.New(f.typ).Elem()
+	if err := d.decodeDefault(path, defaultValue, f, ctx); err != nil {
+		return false, err
+	}
+
+	return reflect.DeepEqual(value.Interface(), defaultValue.Interface()), nil
+}
+
 func (e *encoder) encodeMap(path string, value reflect.Value, ctx context.Context) error {
 	for _, key := range value.MapKeys() {
 		v := value.MapIndex(key)
-		strKey, err := valueToString(key)
+		strKey, err := keyToString(key)
 		if err != nil {
-			return err
+			return fmt.Errorf("%s: %s", path, err)
+		}
+		if err := validateKey(strKey, e.keyEscaper); err != nil {
+			return fmt.Errorf("%s: %s", path, err)
 		}
-		if err := e.encode(fmt.Sprintf("%s/%s", path, strKey), v, ctx); err != nil {
+		if err := e.encode(fmt.Sprintf("%s/%s", path, e.keyEscaper.Escape(strKey)), v, tagOptions{}, ctx); err != nil {
 			return err
 		}
 	}
@@ -159,14 +387,76 @@ func (e *encoder) encodeMap(path string, value reflect.Value, ctx context.Contex
 }
 
 func (e *encoder) encodeSlice(path string, value reflect.Value, ctx context.Context) error {
+	if e.indexWidth > 0 && value.Len() > 0 && len(strconv.Itoa(value.Len()-1)) > e.indexWidth {
+		// Longer indices would break the lexical order the padding is for.
+		return fmt.Errorf("%s: %d elements don't fit in index width %d", path, value.Len(), e.indexWidth)
+	}
+
+	for i := 0; i < value.Len(); i++ {
+		if err := e.encode(fmt.Sprintf("%s/%0*d", path, e.indexWidth, i), value.Index(i), tagOptions{}, ctx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// encodeKeyedSlice stores every element of a slice of structs under the value
+// of its key field instead of its index. Only elements that are no longer in
+// the slice are deleted and elements whose stored value is unchanged are
+// skipped, so inserting an element does not rewrite the others.
+func (e *encoder) encodeKeyedSlice(path string, value reflect.Value, key string, ctx context.Context) error {
+	names := make(map[string]bool, value.Len())
+	for i := 0; i < value.Len(); i++ {
+		name, err := sliceElementKey(value.Index(i), key)
+		if err != nil {
+			return err
+		}
+		if err := validateKey(name, e.keyEscaper); err != nil {
+			return fmt.Errorf("%s: %s", path, err)
+		}
+		name = e.keyEscaper.Escape(name)
+		if names[name] {
+			return fmt.Errorf("%s: duplicate key %s=%s", path, key, name)
+		}
+		names[name] = true
+	}
+
+	stored := make(map[string]*client.Node)
+	if r, err := e.client.Get(ctx, path, &client.GetOptions{Recursive: true}); err == nil {
+		for _, node := range r.Node.Nodes {
+			p := strings.Split(node.Key, "/")
+			if !names[p[len(p)-1]] {
+				e.deleteNode(node.Key, ctx)
+			} else {
+				stored[p[len(p)-1]] = node
+			}
+		}
+	}
+
 	for i := 0; i < value.Len(); i++ {
-		if err := e.encode(fmt.Sprintf("%s/%d", path, i), value.Index(i), ctx); err != nil {
+		name, _ := sliceElementKey(value.Index(i), key)
+		name = e.keyEscaper.Escape(name)
+		if node, ok := stored[name]; ok && e.isStored(node, value.Index(i), ctx) {
+			continue
+		}
+		if err := e.encode(fmt.Sprintf("%s/%s", path, name), value.Index(i), tagOptions{}, ctx); err != nil {
 			return err
 		}
 	}
 	return nil
 }
 
+// isStored reports whether node decodes to value, so writing value again
+// would only notify watchers without changing anything.
+func (e *encoder) isStored(node *client.Node, value reflect.Value, ctx context.Context) bool {
+	d := &decoder{client: e.client, options: e.options}
+	storedValue := reflect.New(value.Type()).Elem()
+	if err := d.decodeNode(node, storedValue, tagOptions{}, ctx); err != nil {
+		return false
+	}
+	return reflect.DeepEqual(value.Interface(), storedValue.Interface())
+}
+
 func (e *encoder) deleteNode(path string, ctx context.Context) {
 	opt := &client.DeleteOptions{
 		Recursive: true,
@@ -188,6 +478,201 @@ func (e *encoder) setNode(path string, value string, ctx context.Context) error
 	return nil
 }
 
+// encodeInOrderSlice treats the slice as an append-only queue: elements that
+// are already stored under path are left untouched and the remaining ones are
+// pushed with CreateInOrder. Every element is stored as a single value, so
+// structs, maps and slices are stored as JSON. The stored elements have to be
+// a prefix of the slice; removing or changing them is an error.
+func (e *encoder) encodeInOrderSlice(path string, value reflect.Value, ctx context.Context) error {
+	var nodes client.Nodes
+	r, err := e.client.Get(ctx, path, nil)
+	if err == nil {
+		nodes = make(client.Nodes, len(r.Node.Nodes))
+		copy(nodes, r.Node.Nodes)
+	} else if ce, ok := err.(client.Error); !ok || ce.Code != client.ErrorCodeKeyNotFound {
+		return err
+	}
+
+	stored := len(nodes)
+	if value.Len() < stored {
+		return fmt.Errorf("%s: %d elements are stored but the slice has %d, in-order slices can only be appended to", path, stored, value.Len())
+	}
+
+	sort.SliceStable(nodes, func(i, j int) bool {
+		return nodes[i].CreatedIndex < nodes[j].CreatedIndex
+	})
+	d := &decoder{client: e.client, options: e.options}
+	for i, node := range nodes {
+		storedValue := reflect.New(value.Type().Elem()).Elem()
+		if err := d.decodeValue(node, storedValue, ctx); err != nil || !reflect.DeepEqual(value.Index(i).Interface(), storedValue.Interface()) {
+			return fmt.Errorf("%s: element %d differs from the stored one, in-order slices can only be appended to", path, i)
+		}
+	}
+
+	op, ok := ctx.Value("options").(*client.CreateInOrderOptions)
+	if !ok {
+		op = &client.CreateInOrderOptions{}
+	}
+
+	for i := stored; i < value.Len(); i++ {
+		s, err := e.encodeValue(value.Index(i))
+		if err != nil {
+			return err
+		}
+		if _, err := e.client.CreateInOrder(ctx, path, s, op); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// encodeValue returns the single value an element of an in-order slice is
+// stored as.
+func (e *encoder) encodeValue(value reflect.Value) (string, error) {
+	if !value.IsValid() {
+		return "", errors.New("can't encode nil value")
+	}
+
+	if encode, ok := e.registry.encoder(value.Type()); ok {
+		return encode(value)
+	}
+
+	en, err := e.registry.enum(value.Type())
+	if err != nil {
+		return "", err
+	}
+	if en != nil {
+		return en.encode(value)
+	}
+
+	if value.Type() == timeType {
+		return formatTime(value.Interface().(time.Time), ""), nil
+	}
+
+	em, m, tm := e.indirect(value)
+	if em != nil {
+		return "", fmt.Errorf("can't store %s as a single value: it implements etcd.Marshaler", value.Type())
+	}
+
+	if m != nil {
+		s, err := m.MarshalJSON()
+		return string(s), err
+	}
+
+	if tm != nil {
+		s, err := tm.MarshalText()
+		return string(s), err
+	}
+
+	for value.Kind() == reflect.Ptr || value.Kind() == reflect.Interface {
+		value = value.Elem()
+	}
+
+	if isBytes(value.Type()) {
+		return bytesToString(value, "")
+	}
+
+	if isComposite(value.Type()) {
+		s, err := json.Marshal(value.Interface())
+		return string(s), err
+	}
+	return valueToString(value)
+}
+
+func sliceElementKey(value reflect.Value, key string) (string, error) {
+	for value.Kind() == reflect.Ptr || value.Kind() == reflect.Interface {
+		if value.IsNil() {
+			return "", fmt.Errorf("can't key nil slice element by %s", key)
+		}
+		value = value.Elem()
+	}
+	if value.Kind() != reflect.Struct {
+		return "", fmt.Errorf("can't key slice element of type %s by %s: not a struct", value.Type(), key)
+	}
+
+	field := value.FieldByName(key)
+	if !field.IsValid() {
+		return "", fmt.Errorf("%s has no field %s", value.Type(), key)
+	}
+
+	return keyToString(field)
+}
+
+// keyToString converts a map key to a string. Keys of string, integer, float
+// and bool kinds and keys implementing encoding.TextMarshaler are supported.
+func keyToString(key reflect.Value) (string, error) {
+	if key.Kind() == reflect.String {
+		return key.String(), nil
+	}
+
+	if key.Type().Implements(textMarshalerType) {
+		if key.Kind() == reflect.Ptr && key.IsNil() {
+			return "", errors.New("can't use nil map key")
+		}
+		s, err := key.Interface().(encoding.TextMarshaler).MarshalText()
+		return string(s), err
+	}
+
+	if reflect.PtrTo(key.Type()).Implements(textMarshalerType) {
+		v := reflect.New(key.Type())
+		v.Elem().Set(key)
+		s, err := v.Interface().(encoding.TextMarshaler).MarshalText()
+		return string(s), err
+	}
+
+	switch key.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr,
+		reflect.Float32, reflect.Float64, reflect.Bool:
+		return valueToString(key)
+	}
+
+	return "", fmt.Errorf("unsupported map key type %s", key.Type())
+}
+
+// isComposite reports whether values of type t are stored as a dir.
+func isComposite(t reflect.Type) bool {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	if isBytes(t) {
+		return false
+	}
+
+	switch t.Kind() {
+	case reflect.Struct, reflect.Map, reflect.Slice, reflect.Array:
+		return true
+	}
+	return false
+}
+
+// valueToString formats a scalar value so that decodePrimitive reads back
+// exactly the same value. Floats are written without an exponent and with
+// the precision of their own bit size.
 func valueToString(val reflect.Value) (string, error) {
-	return fmt.Sprint(val), nil
+	switch val.Kind() {
+	case reflect.Bool:
+		return strconv.FormatBool(val.Bool()), nil
+
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if val.Type() == durationType {
+			return time.Duration(val.Int()).String(), nil
+		}
+		return strconv.FormatInt(val.Int(), 10), nil
+
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return strconv.FormatUint(val.Uint(), 10), nil
+
+	case reflect.Float32, reflect.Float64:
+		return strconv.FormatFloat(val.Float(), 'f', -1, val.Type().Bits()), nil
+
+	case reflect.Complex64, reflect.Complex128:
+		return formatComplex(val.Complex(), val.Type().Bits()), nil
+
+	case reflect.String:
+		return val.String(), nil
+	}
+
+	return "", fmt.Errorf("can't encode value of type %s", val.Type())
 }
@@ -1,7 +1,17 @@
 package etcd
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"net/url"
+	"reflect"
+	"regexp"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
@@ -237,3 +247,852 @@ func TestEncodeIgnoreTag(t *testing.T) {
 	err := encoder.Encode("/path/to/some/struct", s)
 	assert.Nil(t, err)
 }
+
+func TestEncodeSliceWithIndexWidth(t *testing.T) {
+	etcd := new(test.KeysAPIMock)
+	etcd.On("Set", mock.Anything, "/path/to/some/slice/000", mock.Anything, mock.AnythingOfType("*client.SetOptions")).Return(&client.Response{}, nil).Run(func(args mock.Arguments) {
+		assert.Equal(t, "10", args.Get(2))
+	})
+	etcd.On("Set", mock.Anything, "/path/to/some/slice/001", mock.Anything, mock.AnythingOfType("*client.SetOptions")).Return(&client.Response{}, nil).Run(func(args mock.Arguments) {
+		assert.Equal(t, "20", args.Get(2))
+	})
+
+	var s = []int{10, 20}
+	encoder := NewEncoder(etcd, WithIndexWidth(3))
+	err := encoder.Encode("/path/to/some/slice", s)
+	assert.Nil(t, err)
+	etcd.AssertNumberOfCalls(t, "Set", 2)
+
+	err = NewEncoder(etcd, WithIndexWidth(1)).Encode("/path/to/some/slice", make([]int, 11))
+	assert.Equal(t, "/path/to/some/slice: 11 elements don't fit in index width 1", err.Error())
+	etcd.AssertNumberOfCalls(t, "Set", 2)
+}
+
+type keyedBackend struct {
+	Name string
+	Port int
+}
+
+func TestEncodeKeyedSlice(t *testing.T) {
+	etcd := new(test.KeysAPIMock)
+	etcd.On("Get", mock.Anything, "/path/to/struct/backends", mock.Anything).Return(&client.Response{Node: &client.Node{
+		Dir: true,
+		Nodes: []*client.Node{
+			&client.Node{Key: "/path/to/struct/backends/old", Dir: true},
+		},
+	}}, nil)
+	etcd.On("Set", mock.Anything, "/path/to/struct/backends/b/Name", "b", mock.AnythingOfType("*client.SetOptions")).Return(&client.Response{}, nil)
+	etcd.On("Set", mock.Anything, "/path/to/struct/backends/b/Port", "81", mock.AnythingOfType("*client.SetOptions")).Return(&client.Response{}, nil)
+	etcd.On("Set", mock.Anything, "/path/to/struct/backends/a/Name", "a", mock.AnythingOfType("*client.SetOptions")).Return(&client.Response{}, nil)
+	etcd.On("Set", mock.Anything, "/path/to/struct/backends/a/Port", "80", mock.AnythingOfType("*client.SetOptions")).Return(&client.Response{}, nil)
+
+	var s = struct {
+		Backends []keyedBackend `etcd:"backends,key=Name"`
+	}{
+		Backends: []keyedBackend{{Name: "b", Port: 81}, {Name: "a", Port: 80}},
+	}
+
+	encoder := NewEncoder(etcd)
+	err := encoder.Encode("/path/to/struct", s)
+	assert.Nil(t, err)
+	etcd.AssertNumberOfCalls(t, "Set", 4)
+}
+
+func TestEncodeKeyedSliceSkipsUnchanged(t *testing.T) {
+	etcd := new(test.KeysAPIMock)
+	etcd.On("Get", mock.Anything, "/path/to/struct/backends", mock.Anything).Return(&client.Response{Node: &client.Node{
+		Dir: true,
+		Nodes: []*client.Node{
+			&client.Node{Key: "/path/to/struct/backends/a", Dir: true, Nodes: []*client.Node{
+				&client.Node{Key: "/path/to/struct/backends/a/Name", Value: "a"},
+				&client.Node{Key: "/path/to/struct/backends/a/Port", Value: "80"},
+			}},
+			&client.Node{Key: "/path/to/struct/backends/c", Dir: true, Nodes: []*client.Node{
+				&client.Node{Key: "/path/to/struct/backends/c/Name", Value: "c"},
+				&client.Node{Key: "/path/to/struct/backends/c/Port", Value: "82"},
+			}},
+		},
+	}}, nil)
+	etcd.On("Set", mock.Anything, "/path/to/struct/backends/b/Name", "b", mock.AnythingOfType("*client.SetOptions")).Return(&client.Response{}, nil)
+	etcd.On("Set", mock.Anything, "/path/to/struct/backends/b/Port", "81", mock.AnythingOfType("*client.SetOptions")).Return(&client.Response{}, nil)
+	etcd.On("Set", mock.Anything, "/path/to/struct/backends/c/Name", "c", mock.AnythingOfType("*client.SetOptions")).Return(&client.Response{}, nil)
+	etcd.On("Set", mock.Anything, "/path/to/struct/backends/c/Port", "83", mock.AnythingOfType("*client.SetOptions")).Return(&client.Response{}, nil)
+
+	var s = struct {
+		Backends []keyedBackend `etcd:"backends,key=Name"`
+	}{
+		Backends: []keyedBackend{{Name: "a", Port: 80}, {Name: "b", Port: 81}, {Name: "c", Port: 83}},
+	}
+
+	encoder := NewEncoder(etcd)
+	err := encoder.Encode("/path/to/struct", s)
+	assert.Nil(t, err)
+	etcd.AssertNumberOfCalls(t, "Set", 4)
+}
+
+func TestEncodeKeyedSliceDuplicateKey(t *testing.T) {
+	var s = struct {
+		Backends []keyedBackend `etcd:"backends,key=Name"`
+	}{
+		Backends: []keyedBackend{{Name: "a", Port: 81}, {Name: "a", Port: 80}},
+	}
+
+	encoder := NewEncoder(new(test.KeysAPIMock))
+	err := encoder.Encode("/path/to/struct", s)
+	assert.Equal(t, "/path/to/struct/backends: duplicate key Name=a", err.Error())
+}
+
+func TestEncodeInOrderSlice(t *testing.T) {
+	etcd := new(test.KeysAPIMock)
+	etcd.On("Get", mock.Anything, "/path/to/struct/jobs", mock.Anything).Return(&client.Response{Node: &client.Node{
+		Dir: true,
+		Nodes: []*client.Node{
+			&client.Node{Key: "/path/to/struct/jobs/00000000000000000004", Value: `{"Name":"a","Port":80}`},
+		},
+	}}, nil)
+	etcd.On("CreateInOrder", mock.Anything, "/path/to/struct/jobs", `{"Name":"b","Port":81}`, mock.AnythingOfType("*client.CreateInOrderOptions")).Return(&client.Response{}, nil)
+
+	var s = struct {
+		Jobs []keyedBackend `etcd:"jobs,inorder"`
+	}{
+		Jobs: []keyedBackend{{Name: "a", Port: 80}, {Name: "b", Port: 81}},
+	}
+
+	encoder := NewEncoder(etcd)
+	err := encoder.Encode("/path/to/struct", s)
+	assert.Nil(t, err)
+	etcd.AssertNumberOfCalls(t, "CreateInOrder", 1)
+}
+
+func TestEncodeInOrderSliceRejectsChanges(t *testing.T) {
+	etcd := new(test.KeysAPIMock)
+	etcd.On("Get", mock.Anything, "/path/to/jobs", mock.Anything).Return(&client.Response{Node: &client.Node{
+		Dir: true,
+		Nodes: []*client.Node{
+			&client.Node{Key: "/path/to/jobs/00000000000000000004", Value: "x", CreatedIndex: 4},
+			&client.Node{Key: "/path/to/jobs/00000000000000000005", Value: "y", CreatedIndex: 5},
+		},
+	}}, nil)
+
+	var s = struct {
+		Jobs []string `etcd:"jobs,inorder"`
+	}{[]string{"x"}}
+
+	encoder := NewEncoder(etcd)
+	err := encoder.Encode("/path/to", s)
+	assert.Equal(t, "/path/to/jobs: 2 elements are stored but the slice has 1, in-order slices can only be appended to", err.Error())
+
+	s.Jobs = []string{"x", "q", "r"}
+	err = encoder.Encode("/path/to", s)
+	assert.Equal(t, "/path/to/jobs: element 1 differs from the stored one, in-order slices can only be appended to", err.Error())
+	etcd.AssertNumberOfCalls(t, "CreateInOrder", 0)
+}
+
+func TestEncodeMapEscapesKeys(t *testing.T) {
+	etcd := new(test.KeysAPIMock)
+	etcd.On("Set", mock.Anything, "/path/to/some/map/a%2Fb", "10", mock.AnythingOfType("*client.SetOptions")).Return(&client.Response{}, nil)
+	etcd.On("Set", mock.Anything, "/path/to/some/map/100%25", "20", mock.AnythingOfType("*client.SetOptions")).Return(&client.Response{}, nil)
+
+	var m = map[string]int{
+		"a/b":  10,
+		"100%": 20,
+	}
+
+	encoder := NewEncoder(etcd, WithKeyEscaper(PercentEscaper))
+	err := encoder.Encode("/path/to/some/map", m)
+	assert.Nil(t, err)
+	etcd.AssertNumberOfCalls(t, "Set", 2)
+}
+
+func TestEncodeMapRejectsSlashWithoutEscaper(t *testing.T) {
+	encoder := NewEncoder(new(test.KeysAPIMock))
+	err := encoder.Encode("/path/to/some/map", map[string]int{"a/b": 10})
+	assert.Equal(t, `/path/to/some/map: key "a/b" contains '/', use WithKeyEscaper to store it`, err.Error())
+
+	var s = struct {
+		Backends []keyedBackend `etcd:"backends,key=Name"`
+	}{
+		Backends: []keyedBackend{{Name: "a/b", Port: 80}},
+	}
+	err = encoder.Encode("/path/to/struct", s)
+	assert.Equal(t, `/path/to/struct/backends: key "a/b" contains '/', use WithKeyEscaper to store it`, err.Error())
+}
+
+func TestEncodeMapBase32Keys(t *testing.T) {
+	etcd := new(test.KeysAPIMock)
+	etcd.On("Set", mock.Anything, "/path/to/some/map/C4NM4", "10", mock.AnythingOfType("*client.SetOptions")).Return(&client.Response{}, nil)
+
+	encoder := NewEncoder(etcd, WithKeyEscaper(Base32Escaper))
+	err := encoder.Encode("/path/to/some/map", map[string]int{"a/b": 10})
+	assert.Nil(t, err)
+	etcd.AssertNumberOfCalls(t, "Set", 1)
+}
+
+func TestEncodeMapInvalidKeys(t *testing.T) {
+	encoder := NewEncoder(new(test.KeysAPIMock))
+
+	for _, key := range []string{"", ".", ".."} {
+		err := encoder.Encode("/path/to/some/map", map[string]int{key: 10})
+		assert.Equal(t, fmt.Sprintf("/path/to/some/map: invalid key %q", key), err.Error())
+	}
+}
+
+func (k textKey) MarshalText() ([]byte, error) {
+	return []byte(k.A + ":" + k.B), nil
+}
+
+func TestEncodeMapWithTextMarshalerKeys(t *testing.T) {
+	etcd := new(test.KeysAPIMock)
+	etcd.On("Set", mock.Anything, "/path/to/some/map/a:b", "10", mock.AnythingOfType("*client.SetOptions")).Return(&client.Response{}, nil)
+
+	encoder := NewEncoder(etcd)
+	err := encoder.Encode("/path/to/some/map", map[textKey]int{{A: "a", B: "b"}: 10})
+	assert.Nil(t, err)
+	etcd.AssertNumberOfCalls(t, "Set", 1)
+
+	err = encoder.Encode("/path/to/some/map", map[[2]int]int{{1, 2}: 10})
+	assert.Equal(t, "/path/to/some/map: unsupported map key type [2]int", err.Error())
+}
+
+type EmbeddedBase struct {
+	ID   int
+	Name string
+}
+
+type EmbeddedMeta struct {
+	Name string
+}
+
+func TestEncodeEmbeddedStruct(t *testing.T) {
+	etcd := new(test.KeysAPIMock)
+	etcd.On("Set", mock.Anything, "/path/to/some/struct/ID", "1", mock.AnythingOfType("*client.SetOptions")).Return(&client.Response{}, nil)
+	etcd.On("Set", mock.Anything, "/path/to/some/struct/Name", "outer", mock.AnythingOfType("*client.SetOptions")).Return(&client.Response{}, nil)
+	etcd.On("Set", mock.Anything, "/path/to/some/struct/meta/Name", "meta", mock.AnythingOfType("*client.SetOptions")).Return(&client.Response{}, nil)
+
+	var s = struct {
+		EmbeddedBase
+		*EmbeddedMeta `etcd:"meta"`
+		Name          string
+	}{
+		EmbeddedBase: EmbeddedBase{ID: 1, Name: "base"},
+		EmbeddedMeta: &EmbeddedMeta{Name: "meta"},
+		Name:         "outer",
+	}
+
+	encoder := NewEncoder(etcd)
+	err := encoder.Encode("/path/to/some/struct", s)
+	assert.Nil(t, err)
+	etcd.AssertNumberOfCalls(t, "Set", 3)
+}
+
+func TestEncodeEmbeddedNilPointer(t *testing.T) {
+	etcd := new(test.KeysAPIMock)
+	etcd.On("Set", mock.Anything, "/path/to/some/struct/Field", "10", mock.AnythingOfType("*client.SetOptions")).Return(&client.Response{}, nil)
+
+	var s = struct {
+		*EmbeddedBase
+		Field int
+	}{
+		Field: 10,
+	}
+
+	encoder := NewEncoder(etcd)
+	err := encoder.Encode("/path/to/some/struct", s)
+	assert.Nil(t, err)
+	etcd.AssertNumberOfCalls(t, "Set", 1)
+}
+
+func TestEncodeAmbiguousEmbeddedFields(t *testing.T) {
+	etcd := new(test.KeysAPIMock)
+	etcd.On("Set", mock.Anything, "/path/to/some/struct/ID", "1", mock.AnythingOfType("*client.SetOptions")).Return(&client.Response{}, nil)
+
+	var s = struct {
+		EmbeddedBase
+		EmbeddedMeta `etcd:",inline"`
+	}{
+		EmbeddedBase: EmbeddedBase{ID: 1, Name: "base"},
+		EmbeddedMeta: EmbeddedMeta{Name: "meta"},
+	}
+
+	encoder := NewEncoder(etcd)
+	err := encoder.Encode("/path/to/some/struct", s)
+	assert.Nil(t, err)
+	etcd.AssertNumberOfCalls(t, "Set", 1)
+}
+
+type embeddedPrivate struct {
+	Exported int
+}
+
+func TestEncodeSkipsUnexportedFields(t *testing.T) {
+	etcd := new(test.KeysAPIMock)
+	etcd.On("Set", mock.Anything, "/path/to/some/struct/Field", "10", mock.AnythingOfType("*client.SetOptions")).Return(&client.Response{}, nil)
+	etcd.On("Set", mock.Anything, "/path/to/some/struct/Exported", "20", mock.AnythingOfType("*client.SetOptions")).Return(&client.Response{}, nil)
+
+	var s = struct {
+		embeddedPrivate
+		Field  int
+		cache  map[string]int
+		hidden int `etcd:"hidden"`
+		mu     sync.Mutex
+	}{
+		embeddedPrivate: embeddedPrivate{Exported: 20},
+		Field:           10,
+		cache:           map[string]int{"a": 1},
+		hidden:          30,
+	}
+
+	encoder := NewEncoder(etcd)
+	err := encoder.Encode("/path/to/some/struct", &s)
+	assert.Nil(t, err)
+	etcd.AssertNumberOfCalls(t, "Set", 2)
+}
+
+func TestEncodeOmitDefaults(t *testing.T) {
+	etcd := new(test.KeysAPIMock)
+	etcd.On("Set", mock.Anything, "/path/to/some/struct/retries", "5", mock.AnythingOfType("*client.SetOptions")).Return(&client.Response{}, nil)
+
+	var s = struct {
+		Timeout time.Duration `etcd:"timeout" default:"5s"`
+		Retries int           `etcd:"retries" default:"3"`
+	}{
+		Timeout: 5 * time.Second,
+		Retries: 5,
+	}
+
+	encoder := NewEncoder(etcd, WithOmitDefaults())
+	err := encoder.Encode("/path/to/some/struct", s)
+	assert.Nil(t, err)
+	etcd.AssertNumberOfCalls(t, "Set", 1)
+}
+
+type redactedCredentials struct {
+	User     string
+	Password string
+}
+
+func (c *redactedCredentials) BeforeEncode(ctx context.Context) error {
+	if path, _ := PathFromContext(ctx); path != "/path/to/struct/Credentials" {
+		return fmt.Errorf("unexpected path %s", path)
+	}
+	c.Password = "***"
+	return nil
+}
+
+func TestEncodeBeforeEncodeHook(t *testing.T) {
+	etcd := new(test.KeysAPIMock)
+	etcd.On("Set", mock.Anything, "/path/to/struct/Credentials/User", "admin", mock.AnythingOfType("*client.SetOptions")).Return(&client.Response{}, nil)
+	etcd.On("Set", mock.Anything, "/path/to/struct/Credentials/Password", "***", mock.AnythingOfType("*client.SetOptions")).Return(&client.Response{}, nil)
+
+	var s = struct {
+		Credentials redactedCredentials
+	}{
+		Credentials: redactedCredentials{User: "admin", Password: "secret"},
+	}
+
+	encoder := NewEncoder(etcd)
+	err := encoder.Encode("/path/to/struct", &s)
+	assert.Nil(t, err)
+	assert.Equal(t, "secret", s.Credentials.Password)
+	etcd.AssertNumberOfCalls(t, "Set", 2)
+}
+
+type certificateBundle struct {
+	Cert, Key, CA string
+}
+
+func (c certificateBundle) MarshalEtcd() (map[string]string, error) {
+	return map[string]string{"cert": c.Cert, "key": c.Key, "ca": c.CA}, nil
+}
+
+func TestEncodeEtcdMarshaler(t *testing.T) {
+	etcd := new(test.KeysAPIMock)
+	etcd.On("Set", mock.Anything, "/path/to/tls/cert", "CERT", mock.AnythingOfType("*client.SetOptions")).Return(&client.Response{}, nil)
+	etcd.On("Set", mock.Anything, "/path/to/tls/key", "KEY", mock.AnythingOfType("*client.SetOptions")).Return(&client.Response{}, nil)
+	etcd.On("Set", mock.Anything, "/path/to/tls/ca", "CA", mock.AnythingOfType("*client.SetOptions")).Return(&client.Response{}, nil)
+
+	encoder := NewEncoder(etcd)
+	err := encoder.Encode("/path/to/tls", certificateBundle{Cert: "CERT", Key: "KEY", CA: "CA"})
+	assert.Nil(t, err)
+	etcd.AssertNumberOfCalls(t, "Set", 3)
+}
+
+type point struct {
+	X, Y int
+}
+
+type shape interface {
+	Area() int
+}
+
+type square int
+
+func (s square) Area() int {
+	return int(s * s)
+}
+
+func TestEncodeRegisteredCodec(t *testing.T) {
+	etcd := new(test.KeysAPIMock)
+	etcd.On("Set", mock.Anything, "/path/to/struct/Point", "1;2", mock.AnythingOfType("*client.SetOptions")).Return(&client.Response{}, nil)
+	etcd.On("Set", mock.Anything, "/path/to/struct/Square", "area:9", mock.AnythingOfType("*client.SetOptions")).Return(&client.Response{}, nil)
+
+	registry := NewRegistry()
+	registry.RegisterCodec(reflect.TypeOf(point{}), func(v reflect.Value) (string, error) {
+		p := v.Interface().(point)
+		return fmt.Sprintf("%d;%d", p.X, p.Y), nil
+	}, nil)
+
+	var s = struct {
+		Point  *point
+		Square square
+	}{
+		Point:  &point{X: 1, Y: 2},
+		Square: 3,
+	}
+
+	encoder := NewEncoder(etcd, WithRegistry(registry))
+	encoder.RegisterCodec(reflect.TypeOf(new(shape)).Elem(), func(v reflect.Value) (string, error) {
+		return fmt.Sprintf("area:%d", v.Interface().(shape).Area()), nil
+	}, nil)
+	err := encoder.Encode("/path/to/struct", s)
+	assert.Nil(t, err)
+	etcd.AssertNumberOfCalls(t, "Set", 2)
+}
+
+func TestEncodeTime(t *testing.T) {
+	at := time.Date(2020, 7, 28, 21, 41, 10, 500, time.UTC)
+
+	etcd := new(test.KeysAPIMock)
+	etcd.On("Set", mock.Anything, "/path/to/struct/default", "2020-07-28T21:41:10.0000005Z", mock.AnythingOfType("*client.SetOptions")).Return(&client.Response{}, nil)
+	etcd.On("Set", mock.Anything, "/path/to/struct/date", "2020-07-28", mock.AnythingOfType("*client.SetOptions")).Return(&client.Response{}, nil)
+	etcd.On("Set", mock.Anything, "/path/to/struct/unix", "1595972470", mock.AnythingOfType("*client.SetOptions")).Return(&client.Response{}, nil)
+	etcd.On("Set", mock.Anything, "/path/to/struct/unixms", "1595972470000", mock.AnythingOfType("*client.SetOptions")).Return(&client.Response{}, nil)
+
+	var s = struct {
+		Default time.Time  `etcd:"default"`
+		Date    time.Time  `etcd:"date,layout=2006-01-02"`
+		Unix    *time.Time `etcd:"unix,layout=unix"`
+		UnixMs  time.Time  `etcd:"unixms,layout=unixms"`
+	}{
+		Default: at,
+		Date:    at,
+		Unix:    &at,
+		UnixMs:  at,
+	}
+
+	encoder := NewEncoder(etcd)
+	err := encoder.Encode("/path/to/struct", s)
+	assert.Nil(t, err)
+	etcd.AssertNumberOfCalls(t, "Set", 4)
+}
+
+func TestEncodeBytes(t *testing.T) {
+	etcd := new(test.KeysAPIMock)
+	etcd.On("Set", mock.Anything, "/path/to/struct/base64", "aGVsbG8=", mock.AnythingOfType("*client.SetOptions")).Return(&client.Response{}, nil)
+	etcd.On("Set", mock.Anything, "/path/to/struct/hex", "0a0b", mock.AnythingOfType("*client.SetOptions")).Return(&client.Response{}, nil)
+	etcd.On("Set", mock.Anything, "/path/to/struct/raw", "-----BEGIN-----", mock.AnythingOfType("*client.SetOptions")).Return(&client.Response{}, nil)
+
+	var s = struct {
+		Base64 []byte  `etcd:"base64"`
+		Hex    [2]byte `etcd:"hex,encoding=hex"`
+		Raw    []byte  `etcd:"raw,encoding=raw"`
+	}{
+		Base64: []byte("hello"),
+		Hex:    [2]byte{10, 11},
+		Raw:    []byte("-----BEGIN-----"),
+	}
+
+	encoder := NewEncoder(etcd)
+	err := encoder.Encode("/path/to/struct", s)
+	assert.Nil(t, err)
+	etcd.AssertNumberOfCalls(t, "Set", 3)
+}
+
+func TestEncodeJSONOption(t *testing.T) {
+	etcd := new(test.KeysAPIMock)
+	etcd.On("Set", mock.Anything, "/path/to/struct/Name", "value", mock.AnythingOfType("*client.SetOptions")).Return(&client.Response{}, nil)
+	etcd.On("Set", mock.Anything, "/path/to/struct/backends", `[{"Name":"a","Port":80}]`, mock.AnythingOfType("*client.SetOptions")).Return(&client.Response{}, nil)
+
+	var s = struct {
+		Name     string
+		Backends []keyedBackend `etcd:"backends,json"`
+	}{
+		Name:     "value",
+		Backends: []keyedBackend{{Name: "a", Port: 80}},
+	}
+
+	encoder := NewEncoder(etcd)
+	err := encoder.Encode("/path/to/struct", s)
+	assert.Nil(t, err)
+	etcd.AssertNumberOfCalls(t, "Set", 2)
+}
+
+func TestConvertToTree(t *testing.T) {
+	etcd := new(test.KeysAPIMock)
+	etcd.On("Get", mock.Anything, "/path/to/backend", mock.AnythingOfType("*client.GetOptions")).Return(&client.Response{Node: &client.Node{
+		Key:   "/path/to/backend",
+		Value: `{"Name":"a","Port":80}`,
+	}}, nil)
+	etcd.On("Set", mock.Anything, "/path/to/backend/Name", "a", mock.AnythingOfType("*client.SetOptions")).Return(&client.Response{}, nil)
+	etcd.On("Set", mock.Anything, "/path/to/backend/Port", "80", mock.AnythingOfType("*client.SetOptions")).Return(&client.Response{}, nil)
+
+	var b keyedBackend
+	err := ConvertToTree(etcd, "/path/to/backend", &b, context.Background())
+	assert.Nil(t, err)
+	assert.Equal(t, keyedBackend{Name: "a", Port: 80}, b)
+	etcd.AssertNumberOfCalls(t, "Set", 2)
+}
+
+func TestConvertToTreeRestoresOnFailure(t *testing.T) {
+	etcd := new(test.KeysAPIMock)
+	etcd.On("Get", mock.Anything, "/path/to/backend", mock.AnythingOfType("*client.GetOptions")).Return(&client.Response{Node: &client.Node{
+		Key:   "/path/to/backend",
+		Value: `{"Name":"a","Port":80}`,
+	}}, nil)
+	etcd.On("Set", mock.Anything, "/path/to/backend/Name", "a", mock.AnythingOfType("*client.SetOptions")).Return(&client.Response{}, nil)
+	etcd.On("Set", mock.Anything, "/path/to/backend/Port", "80", mock.AnythingOfType("*client.SetOptions")).Return(nil, errors.New("connection lost"))
+	etcd.On("Set", mock.Anything, "/path/to/backend", `{"Name":"a","Port":80}`, mock.AnythingOfType("*client.SetOptions")).Return(&client.Response{}, nil)
+
+	var b keyedBackend
+	err := ConvertToTree(etcd, "/path/to/backend", &b, context.Background())
+	assert.Equal(t, "connection lost", err.Error())
+	etcd.AssertCalled(t, "Set", mock.Anything, "/path/to/backend", `{"Name":"a","Port":80}`, mock.AnythingOfType("*client.SetOptions"))
+}
+
+func TestConvertToJSON(t *testing.T) {
+	etcd := new(test.KeysAPIMock)
+	etcd.On("Get", mock.Anything, "/path/to/backend", mock.AnythingOfType("*client.GetOptions")).Return(&client.Response{Node: &client.Node{
+		Key: "/path/to/backend",
+		Dir: true,
+		Nodes: []*client.Node{
+			&client.Node{Key: "/path/to/backend/Name", Value: "a"},
+			&client.Node{Key: "/path/to/backend/Port", Value: "80"},
+		},
+	}}, nil)
+	etcd.On("Set", mock.Anything, "/path/to/backend", `{"Name":"a","Port":80}`, mock.AnythingOfType("*client.SetOptions")).Return(&client.Response{}, nil)
+
+	var b keyedBackend
+	err := ConvertToJSON(etcd, "/path/to/backend", &b, context.Background())
+	assert.Nil(t, err)
+	assert.Equal(t, keyedBackend{Name: "a", Port: 80}, b)
+	etcd.AssertNumberOfCalls(t, "Set", 1)
+}
+
+func TestConvertToJSONRestoresOnFailure(t *testing.T) {
+	etcd := new(test.KeysAPIMock)
+	etcd.On("Get", mock.Anything, "/path/to/backend", mock.AnythingOfType("*client.GetOptions")).Return(&client.Response{Node: &client.Node{
+		Key: "/path/to/backend",
+		Dir: true,
+		Nodes: []*client.Node{
+			&client.Node{Key: "/path/to/backend/Name", Value: "a"},
+			&client.Node{Key: "/path/to/backend/Port", Value: "80"},
+			&client.Node{Key: "/path/to/backend/Extra", Value: "kept"},
+		},
+	}}, nil)
+	etcd.On("Set", mock.Anything, "/path/to/backend", `{"Name":"a","Port":80}`, mock.AnythingOfType("*client.SetOptions")).Return(nil, errors.New("connection lost"))
+	etcd.On("Set", mock.Anything, "/path/to/backend/Name", "a", mock.AnythingOfType("*client.SetOptions")).Return(&client.Response{}, nil)
+	etcd.On("Set", mock.Anything, "/path/to/backend/Port", "80", mock.AnythingOfType("*client.SetOptions")).Return(&client.Response{}, nil)
+	etcd.On("Set", mock.Anything, "/path/to/backend/Extra", "kept", mock.AnythingOfType("*client.SetOptions")).Return(&client.Response{}, nil)
+
+	var b keyedBackend
+	err := ConvertToJSON(etcd, "/path/to/backend", &b, context.Background())
+	assert.Equal(t, "connection lost", err.Error())
+	etcd.AssertNumberOfCalls(t, "Set", 4)
+}
+
+type s3Backend struct {
+	Bucket string
+}
+
+type localBackend struct {
+	Dir string
+}
+
+func TestEncodeTypedInterface(t *testing.T) {
+	etcd := new(test.KeysAPIMock)
+	etcd.On("Set", mock.Anything, "/path/to/struct/backend/kind", "s3", mock.AnythingOfType("*client.SetOptions")).Return(&client.Response{}, nil)
+	etcd.On("Set", mock.Anything, "/path/to/struct/backend/Bucket", "logs", mock.AnythingOfType("*client.SetOptions")).Return(&client.Response{}, nil)
+
+	var s = struct {
+		Backend interface{} `etcd:"backend,typekey=kind"`
+	}{
+		Backend: &s3Backend{Bucket: "logs"},
+	}
+
+	encoder := NewEncoder(etcd)
+	encoder.RegisterType("s3", &s3Backend{})
+	err := encoder.Encode("/path/to/struct", s)
+	assert.Nil(t, err)
+	etcd.AssertNumberOfCalls(t, "Set", 2)
+
+	s.Backend = localBackend{Dir: "/tmp"}
+	err = encoder.Encode("/path/to/struct", s)
+	assert.Equal(t, "can't encode /path/to/struct/backend: type etcd.localBackend is not registered", err.Error())
+}
+
+func TestEncodeRoundTrippableValues(t *testing.T) {
+	var actual interface{}
+	etcd := new(test.KeysAPIMock)
+	etcd.On("Set", mock.Anything, "/path/to/some/value", mock.Anything, mock.AnythingOfType("*client.SetOptions")).Return(&client.Response{}, nil).Run(func(args mock.Arguments) {
+		actual = args.Get(2)
+	})
+	encoder := NewEncoder(etcd)
+
+	cases := []struct {
+		value    interface{}
+		expected string
+	}{
+		{float32(0.1), "0.1"},
+		{float64(1e21), "1000000000000000000000"},
+		{float64(-2.5e-3), "-0.0025"},
+		{uint64(18446744073709551615), "18446744073709551615"},
+		{int8(-128), "-128"},
+		{90 * time.Second, "1m30s"},
+	}
+
+	for _, c := range cases {
+		err := encoder.Encode("/path/to/some/value", c.value)
+		assert.Nil(t, err)
+		assert.Equal(t, c.expected, actual)
+	}
+
+	err := encoder.Encode("/path/to/some/value", func() {})
+	assert.Equal(t, "/path/to/some/value: can't encode value of type func()", err.Error())
+
+	err = encoder.Encode("/path/to/some/value", make(chan int))
+	assert.Equal(t, "/path/to/some/value: can't encode value of type chan int", err.Error())
+}
+
+func TestEncodeStdlibScalars(t *testing.T) {
+	etcd := new(test.KeysAPIMock)
+	etcd.On("Set", mock.Anything, "/path/to/struct/int", "123456789012345678901234567890", mock.AnythingOfType("*client.SetOptions")).Return(&client.Response{}, nil)
+	etcd.On("Set", mock.Anything, "/path/to/struct/float", "1.5", mock.AnythingOfType("*client.SetOptions")).Return(&client.Response{}, nil)
+	etcd.On("Set", mock.Anything, "/path/to/struct/complex", "(1.5-2i)", mock.AnythingOfType("*client.SetOptions")).Return(&client.Response{}, nil)
+	etcd.On("Set", mock.Anything, "/path/to/struct/ip", "10.0.0.1", mock.AnythingOfType("*client.SetOptions")).Return(&client.Response{}, nil)
+	etcd.On("Set", mock.Anything, "/path/to/struct/cidr", "10.0.0.0/8", mock.AnythingOfType("*client.SetOptions")).Return(&client.Response{}, nil)
+	etcd.On("Set", mock.Anything, "/path/to/struct/url", "https://example.com/path?q=1", mock.AnythingOfType("*client.SetOptions")).Return(&client.Response{}, nil)
+	etcd.On("Set", mock.Anything, "/path/to/struct/regexp", "^a+b$", mock.AnythingOfType("*client.SetOptions")).Return(&client.Response{}, nil)
+
+	n, _ := new(big.Int).SetString("123456789012345678901234567890", 10)
+	_, cidr, _ := net.ParseCIDR("10.0.0.0/8")
+	u, _ := url.Parse("https://example.com/path?q=1")
+
+	var s = struct {
+		Int     *big.Int       `etcd:"int"`
+		Float   *big.Float     `etcd:"float"`
+		Complex complex64      `etcd:"complex"`
+		IP      net.IP         `etcd:"ip"`
+		CIDR    *net.IPNet     `etcd:"cidr"`
+		URL     *url.URL       `etcd:"url"`
+		Regexp  *regexp.Regexp `etcd:"regexp"`
+	}{
+		Int:     n,
+		Float:   big.NewFloat(1.5),
+		Complex: complex(1.5, -2),
+		IP:      net.ParseIP("10.0.0.1"),
+		CIDR:    cidr,
+		URL:     u,
+		Regexp:  regexp.MustCompile("^a+b$"),
+	}
+
+	encoder := NewEncoder(etcd)
+	err := encoder.Encode("/path/to/struct", s)
+	assert.Nil(t, err)
+	etcd.AssertNumberOfCalls(t, "Set", 7)
+}
+
+func TestEncodeUnits(t *testing.T) {
+	etcd := new(test.KeysAPIMock)
+	etcd.On("Set", mock.Anything, "/path/to/struct/max_body", "512MiB", mock.AnythingOfType("*client.SetOptions")).Return(&client.Response{}, nil)
+	etcd.On("Set", mock.Anything, "/path/to/struct/buffer", "5MB", mock.AnythingOfType("*client.SetOptions")).Return(&client.Response{}, nil)
+	etcd.On("Set", mock.Anything, "/path/to/struct/odd", "1234", mock.AnythingOfType("*client.SetOptions")).Return(&client.Response{}, nil)
+	etcd.On("Set", mock.Anything, "/path/to/struct/disk", "1TB", mock.AnythingOfType("*client.SetOptions")).Return(&client.Response{}, nil)
+	etcd.On("Set", mock.Anything, "/path/to/struct/quota", "1PB", mock.AnythingOfType("*client.SetOptions")).Return(&client.Response{}, nil)
+	etcd.On("Set", mock.Anything, "/path/to/struct/timeout_ms", "1m30s", mock.AnythingOfType("*client.SetOptions")).Return(&client.Response{}, nil)
+	etcd.On("Set", mock.Anything, "/path/to/struct/retention", "90d", mock.AnythingOfType("*client.SetOptions")).Return(&client.Response{}, nil)
+
+	var s = struct {
+		MaxBody   int64  `etcd:"max_body,unit=bytes"`
+		Buffer    uint32 `etcd:"buffer,unit=bytes"`
+		Odd       int    `etcd:"odd,unit=bytes"`
+		Disk      int64  `etcd:"disk,unit=bytes"`
+		Quota     int64  `etcd:"quota,unit=bytes"`
+		TimeoutMs int    `etcd:"timeout_ms,unit=ms"`
+		Retention int    `etcd:"retention,unit=h"`
+	}{
+		MaxBody:   512 << 20,
+		Buffer:    5000000,
+		Odd:       1234,
+		Disk:      1e12,
+		Quota:     1e15,
+		TimeoutMs: 90000,
+		Retention: 90 * 24,
+	}
+
+	encoder := NewEncoder(etcd)
+	err := encoder.Encode("/path/to/struct", s)
+	assert.Nil(t, err)
+	etcd.AssertNumberOfCalls(t, "Set", 7)
+}
+
+type mode int
+
+const (
+	modeActive mode = iota
+	modeStandby
+)
+
+type level int
+
+func (level) Values() map[string]interface{} {
+	return map[string]interface{}{"debug": level(0), "info": level(1), "error": level(2)}
+}
+
+func TestEncodeEnum(t *testing.T) {
+	etcd := new(test.KeysAPIMock)
+	etcd.On("Set", mock.Anything, "/path/to/struct/mode", "standby", mock.AnythingOfType("*client.SetOptions")).Return(&client.Response{}, nil)
+	etcd.On("Set", mock.Anything, "/path/to/struct/level", "error", mock.AnythingOfType("*client.SetOptions")).Return(&client.Response{}, nil)
+
+	var s = struct {
+		Mode  mode  `etcd:"mode"`
+		Level level `etcd:"level"`
+	}{modeStandby, 2}
+
+	encoder := NewEncoder(etcd)
+	err := encoder.RegisterEnum(map[string]mode{"active": modeActive, "standby": modeStandby})
+	assert.Nil(t, err)
+	err = encoder.Encode("/path/to/struct", s)
+	assert.Nil(t, err)
+	etcd.AssertNumberOfCalls(t, "Set", 2)
+
+	err = encoder.Encode("/path/to/struct/level", level(5))
+	assert.Equal(t, "/path/to/struct/level: 5 is not a valid etcd.level, valid values are: debug, error, info", err.Error())
+
+	err = encoder.RegisterEnum([]mode{modeActive})
+	assert.NotNil(t, err)
+	type toggle int
+	etcd.On("Set", mock.Anything, "/path/to/toggle", "disabled", mock.AnythingOfType("*client.SetOptions")).Return(&client.Response{}, nil)
+	err = encoder.RegisterEnum(map[string]toggle{"off": 0, "disabled": 0, "on": 1})
+	assert.Nil(t, err)
+	for i := 0; i < 10; i++ {
+		err = encoder.Encode("/path/to/toggle", toggle(0))
+		assert.Nil(t, err)
+	}
+	etcd.AssertNotCalled(t, "Set", mock.Anything, "/path/to/toggle", "off", mock.Anything)
+}
+
+type jsonTagged struct {
+	Name     string `json:"name"`
+	Port     int    `json:"port,omitempty"`
+	Secret   string `json:"-"`
+	Override string `json:"json_name" etcd:"etcd_name"`
+	Plain    string
+}
+
+func TestEncodeWithJSONTags(t *testing.T) {
+	etcd := new(test.KeysAPIMock)
+	etcd.On("Set", mock.Anything, "/path/to/struct/name", "api", mock.AnythingOfType("*client.SetOptions")).Return(&client.Response{}, nil)
+	etcd.On("Set", mock.Anything, "/path/to/struct/port", "8080", mock.AnythingOfType("*client.SetOptions")).Return(&client.Response{}, nil)
+	etcd.On("Set", mock.Anything, "/path/to/struct/etcd_name", "etcd", mock.AnythingOfType("*client.SetOptions")).Return(&client.Response{}, nil)
+	etcd.On("Set", mock.Anything, "/path/to/struct/Plain", "plain", mock.AnythingOfType("*client.SetOptions")).Return(&client.Response{}, nil)
+
+	s := jsonTagged{Name: "api", Port: 8080, Secret: "secret", Override: "etcd", Plain: "plain"}
+
+	encoder := NewEncoder(etcd, WithJSONTags())
+	err := encoder.Encode("/path/to/struct", s)
+	assert.Nil(t, err)
+	etcd.AssertNumberOfCalls(t, "Set", 4)
+}
+
+func TestNamingStrategies(t *testing.T) {
+	for name, expected := range map[string][3]string{
+		"IntMapField":   {"int_map_field", "int-map-field", "intMapField"},
+		"HTTPServerID2": {"http_server_id2", "http-server-id2", "httpServerID2"},
+		"ID":            {"id", "id", "id"},
+		"Field2":        {"field2", "field2", "field2"},
+		"Some_Field":    {"some_field", "some-field", "someField"},
+	} {
+		assert.Equal(t, expected[0], SnakeCase(name))
+		assert.Equal(t, expected[1], KebabCase(name))
+		assert.Equal(t, expected[2], LowerCamelCase(name))
+	}
+}
+
+func TestEncodeWithNaming(t *testing.T) {
+	etcd := new(test.KeysAPIMock)
+	etcd.On("Set", mock.Anything, "/path/to/struct/max_conns", "10", mock.AnythingOfType("*client.SetOptions")).Return(&client.Response{}, nil)
+	etcd.On("Set", mock.Anything, "/path/to/struct/Tagged", "tagged", mock.AnythingOfType("*client.SetOptions")).Return(&client.Response{}, nil)
+	etcd.On("Set", mock.Anything, "/path/to/struct/CUSTOM", "custom", mock.AnythingOfType("*client.SetOptions")).Return(&client.Response{}, nil)
+
+	var s = struct {
+		MaxConns int
+		Field    string `etcd:"Tagged"`
+	}{10, "tagged"}
+
+	encoder := NewEncoder(etcd, WithNaming(SnakeCase))
+	err := encoder.Encode("/path/to/struct", s)
+	assert.Nil(t, err)
+
+	var c = struct {
+		Custom string
+	}{"custom"}
+	encoder = NewEncoder(etcd, WithNaming(func(name string) string { return "CUSTOM" }))
+	err = encoder.Encode("/path/to/struct", c)
+	assert.Nil(t, err)
+	etcd.AssertNumberOfCalls(t, "Set", 3)
+}
+
+type deleteRecorder struct {
+	*test.KeysAPIMock
+	deleted []string
+}
+
+func (r *deleteRecorder) Delete(ctx context.Context, key string, opts *client.DeleteOptions) (*client.Response, error) {
+	r.deleted = append(r.deleted, key)
+	return nil, nil
+}
+
+type aliased struct {
+	MaxConns int `etcd:"max_conns,alias=maxConns MaxConns"`
+}
+
+func TestEncodeAliases(t *testing.T) {
+	etcd := new(test.KeysAPIMock)
+	etcd.On("Set", mock.Anything, "/path/to/struct/max_conns", "10", mock.AnythingOfType("*client.SetOptions")).Return(&client.Response{}, nil)
+	etcd.On("Set", mock.Anything, "/path/to/struct/maxConns", "10", mock.AnythingOfType("*client.SetOptions")).Return(&client.Response{}, nil)
+	etcd.On("Set", mock.Anything, "/path/to/struct/MaxConns", "10", mock.AnythingOfType("*client.SetOptions")).Return(&client.Response{}, nil)
+
+	err := NewEncoder(etcd).Encode("/path/to/struct", aliased{10})
+	assert.Nil(t, err)
+	etcd.AssertNumberOfCalls(t, "Set", 1)
+
+	err = NewEncoder(etcd, WithAliases(AliasWrite)).Encode("/path/to/struct", aliased{10})
+	assert.Nil(t, err)
+	etcd.AssertNumberOfCalls(t, "Set", 4)
+
+	recorder := &deleteRecorder{KeysAPIMock: etcd}
+	err = NewEncoder(recorder, WithAliases(AliasDelete)).Encode("/path/to/struct", aliased{10})
+	assert.Nil(t, err)
+	etcd.AssertNumberOfCalls(t, "Set", 5)
+	assert.Equal(t, []string{"/path/to/struct/maxConns", "/path/to/struct/MaxConns"}, recorder.deleted)
+}
+
+func TestEncodeNilInterface(t *testing.T) {
+	etcd := new(test.KeysAPIMock)
+	etcd.On("Set", mock.Anything, "/path/to/struct/Name", "a", mock.AnythingOfType("*client.SetOptions")).Return(&client.Response{}, nil)
+
+	var s = struct {
+		Name  string
+		Value interface{}
+	}{Name: "a"}
+
+	recorder := &deleteRecorder{KeysAPIMock: etcd}
+	encoder := NewEncoder(recorder)
+	err := encoder.Encode("/path/to/struct", s)
+	assert.Nil(t, err)
+	etcd.AssertNumberOfCalls(t, "Set", 1)
+
+	err = encoder.Encode("/path/to/value", nil)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"/path/to/struct/Value", "/path/to/value"}, recorder.deleted)
+}
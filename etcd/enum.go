@@ -0,0 +1,145 @@
+package etcd
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// Enum is implemented by types stored by name instead of by value. Values
+// maps every name to a value of the implementing type.
+type Enum interface {
+	Values() map[string]interface{}
+}
+
+var enumType = reflect.TypeOf(new(Enum)).Elem()
+
+type enum struct {
+	typ    reflect.Type
+	values map[string]reflect.Value
+	names  map[interface{}]string
+}
+
+func newEnum(t reflect.Type, values map[string]reflect.Value) (*enum, error) {
+	en := &enum{
+		typ:    t,
+		values: make(map[string]reflect.Value, len(values)),
+		names:  make(map[interface{}]string, len(values)),
+	}
+	// Names sharing a value are all decoded; the first one in sorted order
+	// is encoded.
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		v := values[name]
+		if !v.Type().ConvertibleTo(t) {
+			return nil, fmt.Errorf("enum value %s of type %s is not a %s", name, v.Type(), t)
+		}
+		v = v.Convert(t)
+		en.values[name] = v
+		if _, ok := en.names[v.Interface()]; !ok {
+			en.names[v.Interface()] = name
+		}
+	}
+	return en, nil
+}
+
+func (en *enum) validNames() string {
+	names := make([]string, 0, len(en.values))
+	for name := range en.values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
+func (en *enum) encode(value reflect.Value) (string, error) {
+	if name, ok := en.names[value.Interface()]; ok {
+		return name, nil
+	}
+	return "", fmt.Errorf("%v is not a valid %s, valid values are: %s", value.Interface(), en.typ, en.validNames())
+}
+
+// decode sets value to the enum value named s. If numeric is set, s may
+// also be one of the values themselves.
+func (en *enum) decode(s string, value reflect.Value, numeric bool) error {
+	if v, ok := en.values[s]; ok {
+		value.Set(v)
+		return nil
+	}
+	if numeric {
+		v := reflect.New(en.typ).Elem()
+		if err := decodePrimitive(s, v); err == nil {
+			if _, ok := en.names[v.Interface()]; ok {
+				value.Set(v)
+				return nil
+			}
+		}
+	}
+	return fmt.Errorf("invalid value %q for %s, valid values are: %s", s, en.typ, en.validNames())
+}
+
+// RegisterEnum stores values of an enum type by name. values has to be a map
+// from names to values of the enum type, e.g. map[string]Mode.
+func (r *Registry) RegisterEnum(values interface{}) error {
+	v := reflect.ValueOf(values)
+	if v.Kind() != reflect.Map || v.Type().Key().Kind() != reflect.String {
+		return fmt.Errorf("can't register enum %T: not a map with string keys", values)
+	}
+
+	m := make(map[string]reflect.Value, v.Len())
+	for _, key := range v.MapKeys() {
+		m[key.String()] = v.MapIndex(key)
+	}
+	en, err := newEnum(v.Type().Elem(), m)
+	if err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.enums[en.typ] = en
+	return nil
+}
+
+// enum returns the enum registered for t or built from t's Values method.
+func (r *Registry) enum(t reflect.Type) (*enum, error) {
+	if t.Kind() == reflect.Ptr || t.Kind() == reflect.Interface {
+		return nil, nil
+	}
+
+	r.mu.RLock()
+	en, ok := r.enums[t]
+	r.mu.RUnlock()
+	if ok {
+		return en, nil
+	}
+
+	var e Enum
+	if t.Implements(enumType) {
+		e = reflect.Zero(t).Interface().(Enum)
+	} else if reflect.PtrTo(t).Implements(enumType) {
+		e = reflect.New(t).Interface().(Enum)
+	} else {
+		return nil, nil
+	}
+
+	m := make(map[string]reflect.Value)
+	for name, v := range e.Values() {
+		m[name] = reflect.ValueOf(v)
+	}
+	en, err := newEnum(t, m)
+	if err != nil {
+		return nil, err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.enums[t] = en
+	return en, nil
+}
@@ -0,0 +1,83 @@
+package etcd
+
+import (
+	"encoding/base32"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// KeyEscaper converts map keys to single etcd path segments and back.
+type KeyEscaper interface {
+	Escape(key string) string
+	Unescape(segment string) (string, error)
+}
+
+var (
+	// NoEscaper stores keys as they are. It is the default, so keys written
+	// by earlier versions decode unchanged; keys containing '/' are rejected.
+	NoEscaper KeyEscaper = noEscaper{}
+
+	// PercentEscaper percent-encodes '%', '/' and control characters and keeps
+	// everything else readable.
+	PercentEscaper KeyEscaper = percentEscaper{}
+
+	// Base32Escaper encodes whole keys with the base32 "extended hex" alphabet,
+	// which preserves the lexical order of keys.
+	Base32Escaper KeyEscaper = base32Escaper{}
+)
+
+type noEscaper struct{}
+
+func (noEscaper) Escape(key string) string {
+	return key
+}
+
+func (noEscaper) Unescape(segment string) (string, error) {
+	return segment, nil
+}
+
+type percentEscaper struct{}
+
+func (percentEscaper) Escape(key string) string {
+	var b strings.Builder
+	for i := 0; i < len(key); i++ {
+		c := key[i]
+		if c == '%' || c == '/' || c < 0x20 || c == 0x7f {
+			fmt.Fprintf(&b, "%%%02X", c)
+		} else {
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+func (percentEscaper) Unescape(segment string) (string, error) {
+	return url.PathUnescape(segment)
+}
+
+var base32Encoding = base32.HexEncoding.WithPadding(base32.NoPadding)
+
+type base32Escaper struct{}
+
+func (base32Escaper) Escape(key string) string {
+	return base32Encoding.EncodeToString([]byte(key))
+}
+
+func (base32Escaper) Unescape(segment string) (string, error) {
+	b, err := base32Encoding.DecodeString(segment)
+	return string(b), err
+}
+
+// validateKey rejects keys that can't be stored as a single path segment
+// with escaper.
+func validateKey(key string, escaper KeyEscaper) error {
+	switch key {
+	case "", ".", "..":
+		return fmt.Errorf("invalid key %q", key)
+	}
+	if escaper == NoEscaper && strings.Contains(key, "/") {
+		return fmt.Errorf("key %q contains '/', use WithKeyEscaper to store it", key)
+	}
+	return nil
+}
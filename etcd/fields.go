@@ -0,0 +1,213 @@
+package etcd
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+)
+
+// field is a struct field stored under its own key. Fields of embedded
+// structs are promoted into the parent, so index may point into an embedded
+// struct, as with reflect.Value.FieldByIndex.
+type field struct {
+	name   string
+	tag    tagOptions
+	index  []int
+	typ    reflect.Type
+	tagged bool
+
+	// defaultValue is the `default:"..."` tag, decoded like a stored value
+	// when the key is missing.
+	defaultValue string
+	hasDefault   bool
+}
+
+// typeFields returns the exported fields of struct type t in declaration
+// order. Anonymous struct fields are flattened unless they are given a name in the
+// tag; conflicting names are resolved with Go's shadowing rules: the
+// shallowest field wins, then a tagged one, and if that is still ambiguous
+// none of them is used.
+func typeFields(t reflect.Type, o options) []field {
+	var fields []field
+
+	current := []field{}
+	next := []field{{typ: t}}
+	var count, nextCount map[reflect.Type]int
+	visited := map[reflect.Type]bool{}
+
+	for len(next) > 0 {
+		current, next = next, current[:0]
+		count, nextCount = nextCount, map[reflect.Type]int{}
+
+		for _, f := range current {
+			if visited[f.typ] {
+				continue
+			}
+			visited[f.typ] = true
+
+			for i := 0; i < f.typ.NumField(); i++ {
+				sf := f.typ.Field(i)
+				tag, ok := fieldTag(sf, o)
+				if !ok {
+					continue
+				}
+
+				index := make([]int, len(f.index)+1)
+				copy(index, f.index)
+				index[len(f.index)] = i
+
+				ft := sf.Type
+				if ft.Name() == "" && ft.Kind() == reflect.Ptr {
+					ft = ft.Elem()
+				}
+
+				if sf.Anonymous && ft.Kind() == reflect.Struct && (tag.name == "" || tag.has("inline") || tag.has("squash")) {
+					nextCount[ft]++
+					if nextCount[ft] == 1 {
+						next = append(next, field{name: ft.Name(), index: index, typ: ft})
+					}
+					continue
+				}
+
+				if sf.PkgPath != "" {
+					// Unexported fields can't be set by the decoder and are
+					// private state, so only the exported fields of
+					// unexported embedded structs are stored.
+					continue
+				}
+
+				name := tag.name
+				if name == "" {
+					name = sf.Name
+					if o.naming != nil {
+						name = o.naming(name)
+					}
+				}
+				defaultValue, hasDefault := sf.Tag.Lookup("default")
+				fields = append(fields, field{
+					name:         name,
+					tag:          tag,
+					index:        index,
+					typ:          sf.Type,
+					tagged:       tag.name != "",
+					defaultValue: defaultValue,
+					hasDefault:   hasDefault,
+				})
+				if count[f.typ] > 1 {
+					// The same struct is embedded twice at this depth, so its
+					// fields are ambiguous. Adding a duplicate makes the
+					// dominance check below drop them.
+					fields = append(fields, fields[len(fields)-1])
+				}
+			}
+		}
+	}
+
+	sort.SliceStable(fields, func(i, j int) bool {
+		if fields[i].name != fields[j].name {
+			return fields[i].name < fields[j].name
+		}
+		if len(fields[i].index) != len(fields[j].index) {
+			return len(fields[i].index) < len(fields[j].index)
+		}
+		return fields[i].tagged && !fields[j].tagged
+	})
+
+	out := fields[:0]
+	for advance, i := 0, 0; i < len(fields); i += advance {
+		name := fields[i].name
+		for advance = 1; i+advance < len(fields); advance++ {
+			if fields[i+advance].name != name {
+				break
+			}
+		}
+		if advance == 1 {
+			out = append(out, fields[i])
+			continue
+		}
+		if dominant, ok := dominantField(fields[i : i+advance]); ok {
+			out = append(out, dominant)
+		}
+	}
+
+	fields = out
+	sort.Slice(fields, func(i, j int) bool {
+		for k, x := range fields[i].index {
+			if k >= len(fields[j].index) {
+				return false
+			}
+			if x != fields[j].index[k] {
+				return x < fields[j].index[k]
+			}
+		}
+		return len(fields[i].index) < len(fields[j].index)
+	})
+
+	return fields
+}
+
+// fieldTag returns the etcd tag of sf, or with WithJSONTags the name and
+// omitempty option of its json tag if it has no etcd tag. It returns false
+// for skipped fields, tagged `etcd:"-"` or exactly `json:"-"`; as in
+// encoding/json, `json:"-,"` names the key "-".
+func fieldTag(sf reflect.StructField, o options) (tagOptions, bool) {
+	if tag, ok := sf.Tag.Lookup("etcd"); ok || !o.jsonTags {
+		t := parseTag(tag)
+		return t, t.name != "-"
+	}
+
+	tag, ok := sf.Tag.Lookup("json")
+	if !ok {
+		return tagOptions{}, true
+	}
+	if tag == "-" {
+		return tagOptions{}, false
+	}
+	json := parseTag(tag)
+	t := tagOptions{name: json.name}
+	if json.has("omitempty") {
+		t.params = map[string]string{"omitempty": ""}
+	}
+	return t, true
+}
+
+// dominantField picks the field that hides the others with the same name.
+// The fields are sorted by depth and then tagged first.
+func dominantField(fields []field) (field, bool) {
+	if len(fields) > 1 && len(fields[0].index) == len(fields[1].index) && fields[0].tagged == fields[1].tagged {
+		return field{}, false
+	}
+	return fields[0], true
+}
+
+// fieldByIndex returns the field of struct v at index. It returns false if
+// the field is promoted through a nil embedded pointer.
+func fieldByIndex(v reflect.Value, index []int) (reflect.Value, bool) {
+	for i, x := range index {
+		if i > 0 && v.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				return reflect.Value{}, false
+			}
+			v = v.Elem()
+		}
+		v = v.Field(x)
+	}
+	return v, true
+}
+
+// allocFieldByIndex is like fieldByIndex but allocates nil embedded pointers.
+func allocFieldByIndex(v reflect.Value, index []int) (reflect.Value, error) {
+	for i, x := range index {
+		if i > 0 && v.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				if !v.CanSet() {
+					return reflect.Value{}, fmt.Errorf("can't set embedded pointer to unexported struct %s", v.Type().Elem())
+				}
+				v.Set(reflect.New(v.Type().Elem()))
+			}
+			v = v.Elem()
+		}
+		v = v.Field(x)
+	}
+	return v, nil
+}
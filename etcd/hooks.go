@@ -0,0 +1,59 @@
+package etcd
+
+import (
+	"context"
+	"reflect"
+)
+
+// BeforeEncoder is implemented by types that need to prepare themselves for
+// encoding, e.g. to normalize values or redact secrets. BeforeEncode is
+// called on a shallow copy of the struct, so changes to its fields are
+// encoded but don't affect the caller's value.
+type BeforeEncoder interface {
+	BeforeEncode(ctx context.Context) error
+}
+
+// AfterDecoder is implemented by types that need to finish decoding, e.g. to
+// fill derived fields. AfterDecode is called after all fields of the struct
+// have been decoded and before Validate.
+type AfterDecoder interface {
+	AfterDecode(ctx context.Context) error
+}
+
+var (
+	beforeEncoderType = reflect.TypeOf(new(BeforeEncoder)).Elem()
+	afterDecoderType  = reflect.TypeOf(new(AfterDecoder)).Elem()
+)
+
+type pathContextKey struct{}
+
+// PathFromContext returns the etcd path of the struct a BeforeEncode or
+// AfterDecode hook is called for.
+func PathFromContext(ctx context.Context) (string, bool) {
+	path, ok := ctx.Value(pathContextKey{}).(string)
+	return path, ok
+}
+
+// beforeEncode calls the BeforeEncode hook of the struct value and returns
+// the copy it was called on. It returns value itself if there is no hook.
+func beforeEncode(path string, value reflect.Value, ctx context.Context) (reflect.Value, error) {
+	if !reflect.PtrTo(value.Type()).Implements(beforeEncoderType) {
+		return value, nil
+	}
+
+	v := reflect.New(value.Type())
+	v.Elem().Set(value)
+	if err := v.Interface().(BeforeEncoder).BeforeEncode(context.WithValue(ctx, pathContextKey{}, path)); err != nil {
+		return value, err
+	}
+	return v.Elem(), nil
+}
+
+// afterDecode calls the AfterDecode hook of the decoded struct value.
+func afterDecode(path string, value reflect.Value, ctx context.Context) error {
+	if !value.CanAddr() || !value.Addr().Type().Implements(afterDecoderType) {
+		return nil
+	}
+
+	return value.Addr().Interface().(AfterDecoder).AfterDecode(context.WithValue(ctx, pathContextKey{}, path))
+}
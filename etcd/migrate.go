@@ -0,0 +1,96 @@
+package etcd
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"go.etcd.io/etcd/v3/client"
+)
+
+// ConvertToJSON migrates the value stored under path from the tree layout to
+// the single JSON value used by the "json" tag option. v has to be a pointer
+// to the type stored under path and holds the value afterwards.
+//
+// The migration is not atomic: the tree is deleted before the JSON value is
+// written. If writing fails the original keys are restored, but readers may
+// see the key missing in between.
+func ConvertToJSON(keysAPI client.KeysAPI, path string, v interface{}, ctx context.Context, opts ...Option) error {
+	r, err := keysAPI.Get(ctx, path, &client.GetOptions{Recursive: true})
+	if err != nil {
+		return err
+	}
+
+	if err := NewDecoder(keysAPI, opts...).DecodeWithContext(path, v, ctx); err != nil {
+		return err
+	}
+
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	if _, err := keysAPI.Delete(ctx, path, &client.DeleteOptions{Recursive: true, Dir: true}); err != nil {
+		return err
+	}
+
+	if _, err := keysAPI.Set(ctx, path, string(b), &client.SetOptions{}); err != nil {
+		return restoreNode(keysAPI, r.Node, err, ctx)
+	}
+	return nil
+}
+
+// ConvertToTree migrates the value stored under path from a single JSON value
+// to the tree layout, the inverse of ConvertToJSON. Like ConvertToJSON it is
+// not atomic and restores the JSON value if writing the tree fails.
+func ConvertToTree(keysAPI client.KeysAPI, path string, v interface{}, ctx context.Context, opts ...Option) error {
+	r, err := keysAPI.Get(ctx, path, &client.GetOptions{})
+	if err != nil {
+		return err
+	}
+	if r.Node.Dir {
+		return errors.New(fmt.Sprintf("%s is a dir", path))
+	}
+
+	if err := json.Unmarshal([]byte(r.Node.Value), v); err != nil {
+		return fmt.Errorf("can't decode %s: %s", path, err)
+	}
+
+	if _, err := keysAPI.Delete(ctx, path, &client.DeleteOptions{}); err != nil {
+		return err
+	}
+
+	if err := NewEncoder(keysAPI, opts...).EncodeWithContext(path, v, ctx); err != nil {
+		return restoreNode(keysAPI, r.Node, err, ctx)
+	}
+	return nil
+}
+
+// restoreNode replaces whatever is stored under node.Key with node and its
+// children after a failed migration. It returns cause, annotated if
+// restoring fails too.
+func restoreNode(keysAPI client.KeysAPI, node *client.Node, cause error, ctx context.Context) error {
+	keysAPI.Delete(ctx, node.Key, &client.DeleteOptions{Recursive: true, Dir: true})
+	if err := setNodes(keysAPI, node, ctx); err != nil {
+		return fmt.Errorf("%s; restoring %s failed: %s", cause, node.Key, err)
+	}
+	return cause
+}
+
+func setNodes(keysAPI client.KeysAPI, node *client.Node, ctx context.Context) error {
+	if !node.Dir {
+		_, err := keysAPI.Set(ctx, node.Key, node.Value, &client.SetOptions{})
+		return err
+	}
+	if len(node.Nodes) == 0 {
+		_, err := keysAPI.Set(ctx, node.Key, "", &client.SetOptions{Dir: true})
+		return err
+	}
+	for _, n := range node.Nodes {
+		if err := setNodes(keysAPI, n, ctx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,58 @@
+package etcd
+
+import (
+	"strings"
+	"unicode"
+)
+
+// NamingStrategy turns the name of a struct field without a tag name into
+// its key.
+type NamingStrategy func(fieldName string) string
+
+// SnakeCase names keys like "int_map_field".
+func SnakeCase(name string) string {
+	return strings.ToLower(strings.Join(splitWords(name), "_"))
+}
+
+// KebabCase names keys like "int-map-field".
+func KebabCase(name string) string {
+	return strings.ToLower(strings.Join(splitWords(name), "-"))
+}
+
+// LowerCamelCase names keys like "intMapField".
+func LowerCamelCase(name string) string {
+	words := splitWords(name)
+	if len(words) == 0 {
+		return name
+	}
+	words[0] = strings.ToLower(words[0])
+	return strings.Join(words, "")
+}
+
+// splitWords splits a Go identifier into words, keeping acronyms together:
+// "HTTPServerID2" is "HTTP", "Server", "ID2".
+func splitWords(name string) []string {
+	var words []string
+	runes := []rune(name)
+	start := 0
+	for i := 1; i < len(runes); i++ {
+		prev, cur := runes[i-1], runes[i]
+		switch {
+		case cur == '_':
+			if start < i {
+				words = append(words, string(runes[start:i]))
+			}
+			start = i + 1
+		case unicode.IsUpper(cur) && (unicode.IsLower(prev) || unicode.IsDigit(prev)),
+			unicode.IsUpper(cur) && unicode.IsUpper(prev) && i+1 < len(runes) && unicode.IsLower(runes[i+1]):
+			if start < i {
+				words = append(words, string(runes[start:i]))
+			}
+			start = i
+		}
+	}
+	if start < len(runes) {
+		words = append(words, string(runes[start:]))
+	}
+	return words
+}
@@ -0,0 +1,129 @@
+package etcd
+
+// Option configures an Encoder or a Decoder. Options that only make sense
+// for one side are ignored by the other.
+type Option func(*options)
+
+type options struct {
+	indexWidth   int
+	keyEscaper   KeyEscaper
+	omitDefaults bool
+	registry     *Registry
+	weaklyTyped  bool
+	numericEnums bool
+	jsonTags     bool
+	naming       NamingStrategy
+	ignoreCase   bool
+	aliases      AliasMode
+}
+
+func newOptions(opts []Option) options {
+	o := options{
+		keyEscaper: NoEscaper,
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if o.registry == nil {
+		o.registry = NewRegistry()
+	}
+	return o
+}
+
+// WithIndexWidth makes the encoder zero-pad slice indices to the given width,
+// so that etcd's lexical ordering of keys matches the slice order
+// (e.g. "0001", "0002", ..., "0010" instead of "1", "10", "2").
+// The decoder accepts both padded and unpadded indices. Encoding a slice
+// whose indices don't fit in width is an error.
+func WithIndexWidth(width int) Option {
+	return func(o *options) {
+		o.indexWidth = width
+	}
+}
+
+// WithKeyEscaper sets how map keys and slice element keys are turned into
+// path segments. The same escaper has to be used for encoding and decoding.
+func WithKeyEscaper(escaper KeyEscaper) Option {
+	return func(o *options) {
+		o.keyEscaper = escaper
+	}
+}
+
+// WithOmitDefaults makes the encoder delete fields whose value equals their
+// `default:"..."` tag instead of writing them.
+func WithOmitDefaults() Option {
+	return func(o *options) {
+		o.omitDefaults = true
+	}
+}
+
+// WithRegistry makes the encoder or decoder use a shared codec registry
+// instead of its own.
+func WithRegistry(registry *Registry) Option {
+	return func(o *options) {
+		o.registry = registry
+	}
+}
+
+// WithWeaklyTypedInput makes the decoder accept hand-edited values: "yes",
+// "on", "y" and their negations for bools, integral floats such as "1.0" for
+// integers, empty values as zero and a single value for a one-element slice.
+func WithWeaklyTypedInput() Option {
+	return func(o *options) {
+		o.weaklyTyped = true
+	}
+}
+
+// WithNumericEnums makes the decoder accept the values of enum types besides
+// their names.
+func WithNumericEnums() Option {
+	return func(o *options) {
+		o.numericEnums = true
+	}
+}
+
+// WithJSONTags makes fields without an etcd tag use the name and omitempty
+// option of their json tag. Fields tagged `json:"-"` are skipped.
+func WithJSONTags() Option {
+	return func(o *options) {
+		o.jsonTags = true
+	}
+}
+
+// WithNaming sets how fields without a tag name are turned into keys, e.g.
+// WithNaming(SnakeCase). Field names are used as they are by default.
+func WithNaming(naming NamingStrategy) Option {
+	return func(o *options) {
+		o.naming = naming
+	}
+}
+
+// WithCaseInsensitiveKeys makes the decoder match keys to struct fields
+// ignoring case if there is no exact match.
+func WithCaseInsensitiveKeys() Option {
+	return func(o *options) {
+		o.ignoreCase = true
+	}
+}
+
+// AliasMode sets what the encoder does with the old names of fields given in
+// the "alias" tag option.
+type AliasMode int
+
+const (
+	// AliasIgnore leaves keys stored under aliases as they are.
+	AliasIgnore AliasMode = iota
+	// AliasWrite writes fields under their aliases too, for readers that
+	// still use the old names.
+	AliasWrite
+	// AliasDelete deletes keys stored under aliases.
+	AliasDelete
+)
+
+// WithAliases sets what the encoder does with keys stored under field
+// aliases. They are left as they are by default.
+func WithAliases(mode AliasMode) Option {
+	return func(o *options) {
+		o.aliases = mode
+	}
+}
@@ -0,0 +1,112 @@
+package etcd
+
+import (
+	"reflect"
+	"sync"
+)
+
+// EncodeFunc converts a value to the string stored in etcd.
+type EncodeFunc func(value reflect.Value) (string, error)
+
+// DecodeFunc parses a string stored in etcd into value, which is settable.
+type DecodeFunc func(s string, value reflect.Value) error
+
+type codec struct {
+	encode EncodeFunc
+	decode DecodeFunc
+}
+
+// Registry holds codecs for types the encoder and decoder don't support
+// natively. It is safe for concurrent use and can be shared between encoders
+// and decoders with WithRegistry.
+type Registry struct {
+	mu     sync.RWMutex
+	codecs map[reflect.Type]codec
+	ifaces []reflect.Type
+
+	typesByName map[string]reflect.Type
+	typeNames   map[reflect.Type]string
+
+	enums map[reflect.Type]*enum
+}
+
+func NewRegistry() *Registry {
+	return &Registry{
+		codecs:      make(map[reflect.Type]codec),
+		typesByName: make(map[string]reflect.Type),
+		typeNames:   make(map[reflect.Type]string),
+		enums:       make(map[reflect.Type]*enum),
+	}
+}
+
+// RegisterCodec registers functions storing values of type t as a single
+// key. If t is an interface type, the codec is also used for all types
+// implementing it. Either function may be nil to only register the other
+// direction. Codecs take precedence over the built-in encoding.
+func (r *Registry) RegisterCodec(t reflect.Type, encode EncodeFunc, decode DecodeFunc) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.codecs[t]; !ok && t.Kind() == reflect.Interface {
+		r.ifaces = append(r.ifaces, t)
+	}
+	r.codecs[t] = codec{encode: encode, decode: decode}
+}
+
+// RegisterType registers the type of v under name for interface fields
+// tagged with the "typekey" option. The encoder stores name next to the
+// value's fields and the decoder instantiates the type registered under the
+// stored name. v has to be a struct or a pointer to a struct; registering a
+// pointer makes the decoder store pointers in the interface.
+func (r *Registry) RegisterType(name string, v interface{}) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	t := reflect.TypeOf(v)
+	r.typesByName[name] = t
+	r.typeNames[t] = name
+}
+
+func (r *Registry) typeName(t reflect.Type) (string, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	name, ok := r.typeNames[t]
+	return name, ok
+}
+
+func (r *Registry) typeByName(name string) (reflect.Type, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	t, ok := r.typesByName[name]
+	return t, ok
+}
+
+// lookup returns the codec registered for t, or for the first registered
+// interface t implements, or the built-in codec for t.
+func (r *Registry) lookup(t reflect.Type) (codec, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if c, ok := r.codecs[t]; ok {
+		return c, true
+	}
+	for _, iface := range r.ifaces {
+		if t.Implements(iface) {
+			return r.codecs[iface], true
+		}
+	}
+	c, ok := builtinCodecs[t]
+	return c, ok
+}
+
+func (r *Registry) encoder(t reflect.Type) (EncodeFunc, bool) {
+	c, ok := r.lookup(t)
+	return c.encode, ok && c.encode != nil
+}
+
+func (r *Registry) decoder(t reflect.Type) (DecodeFunc, bool) {
+	c, ok := r.lookup(t)
+	return c.decode, ok && c.decode != nil
+}
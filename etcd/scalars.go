@@ -0,0 +1,178 @@
+package etcd
+
+import (
+	"fmt"
+	"math/big"
+	"net"
+	"net/url"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// builtinCodecs store common standard library types as a single value in
+// their canonical string form. Codecs registered by the user take precedence.
+var builtinCodecs = map[reflect.Type]codec{
+	reflect.TypeOf(big.Int{}): {
+		encode: func(v reflect.Value) (string, error) {
+			n := v.Interface().(big.Int)
+			return n.String(), nil
+		},
+		decode: func(s string, v reflect.Value) error {
+			n, ok := new(big.Int).SetString(s, 10)
+			if !ok {
+				return fmt.Errorf("invalid integer %q", s)
+			}
+			v.Set(reflect.ValueOf(*n))
+			return nil
+		},
+	},
+	reflect.TypeOf(big.Float{}): {
+		encode: func(v reflect.Value) (string, error) {
+			f := v.Interface().(big.Float)
+			return f.Text('g', -1), nil
+		},
+		decode: func(s string, v reflect.Value) error {
+			// Four bits per digit keep every digit written by Text.
+			prec := uint(len(s) * 4)
+			if prec < 64 {
+				prec = 64
+			}
+			f, ok := new(big.Float).SetPrec(prec).SetString(s)
+			if !ok {
+				return fmt.Errorf("invalid float %q", s)
+			}
+			v.Set(reflect.ValueOf(*f))
+			return nil
+		},
+	},
+	reflect.TypeOf(net.IP{}): {
+		encode: func(v reflect.Value) (string, error) {
+			ip := v.Interface().(net.IP)
+			if len(ip) == 0 {
+				return "", nil
+			}
+			return ip.String(), nil
+		},
+		decode: func(s string, v reflect.Value) error {
+			if s == "" {
+				v.Set(reflect.ValueOf(net.IP(nil)))
+				return nil
+			}
+			ip := net.ParseIP(s)
+			if ip == nil {
+				return fmt.Errorf("invalid IP address %q", s)
+			}
+			v.Set(reflect.ValueOf(ip))
+			return nil
+		},
+	},
+	reflect.TypeOf(net.IPNet{}): {
+		encode: func(v reflect.Value) (string, error) {
+			n := v.Interface().(net.IPNet)
+			if len(n.IP) == 0 {
+				return "", nil
+			}
+			return n.String(), nil
+		},
+		decode: func(s string, v reflect.Value) error {
+			if s == "" {
+				v.Set(reflect.ValueOf(net.IPNet{}))
+				return nil
+			}
+			_, n, err := net.ParseCIDR(s)
+			if err != nil {
+				return fmt.Errorf("invalid CIDR %q", s)
+			}
+			v.Set(reflect.ValueOf(*n))
+			return nil
+		},
+	},
+	reflect.TypeOf(url.URL{}): {
+		encode: func(v reflect.Value) (string, error) {
+			u := v.Interface().(url.URL)
+			return u.String(), nil
+		},
+		decode: func(s string, v reflect.Value) error {
+			u, err := url.Parse(s)
+			if err != nil {
+				return err
+			}
+			v.Set(reflect.ValueOf(*u))
+			return nil
+		},
+	},
+	reflect.TypeOf(regexp.Regexp{}): {
+		encode: func(v reflect.Value) (string, error) {
+			re := v.Interface().(regexp.Regexp)
+			return re.String(), nil
+		},
+		decode: func(s string, v reflect.Value) error {
+			re, err := regexp.Compile(s)
+			if err != nil {
+				return err
+			}
+			v.Set(reflect.ValueOf(re).Elem())
+			return nil
+		},
+	},
+}
+
+// formatComplex formats c as "(re+imi)", each part with half of bitSize.
+func formatComplex(c complex128, bitSize int) string {
+	re := strconv.FormatFloat(real(c), 'f', -1, bitSize/2)
+	im := strconv.FormatFloat(imag(c), 'f', -1, bitSize/2)
+	if !strings.HasPrefix(im, "-") && !strings.HasPrefix(im, "+") {
+		im = "+" + im
+	}
+	return "(" + re + im + "i)"
+}
+
+// parseComplex parses the output of formatComplex as well as plain real
+// ("1.5") and imaginary ("2i") numbers.
+func parseComplex(s string, bitSize int) (complex128, error) {
+	orig := s
+	if strings.HasPrefix(s, "(") && strings.HasSuffix(s, ")") {
+		s = s[1 : len(s)-1]
+	}
+
+	invalid := func(err error) (complex128, error) {
+		if ne, ok := err.(*strconv.NumError); ok && ne.Err == strconv.ErrRange {
+			return 0, err
+		}
+		return 0, fmt.Errorf("invalid complex number %q", orig)
+	}
+
+	if !strings.HasSuffix(s, "i") {
+		re, err := strconv.ParseFloat(s, bitSize/2)
+		if err != nil {
+			return invalid(err)
+		}
+		return complex(re, 0), nil
+	}
+	s = s[:len(s)-1]
+
+	// The imaginary part starts at the last sign that is neither leading nor
+	// part of an exponent.
+	split := 0
+	for i := len(s) - 1; i > 0; i-- {
+		if (s[i] == '+' || s[i] == '-') && s[i-1] != 'e' && s[i-1] != 'E' {
+			split = i
+			break
+		}
+	}
+
+	var re float64
+	var err error
+	if split > 0 {
+		if re, err = strconv.ParseFloat(s[:split], bitSize/2); err != nil {
+			return invalid(err)
+		}
+	}
+	im, err := strconv.ParseFloat(s[split:], bitSize/2)
+	if err != nil {
+		return invalid(err)
+	}
+	return complex(re, im), nil
+}
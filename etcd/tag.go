@@ -0,0 +1,60 @@
+package etcd
+
+import (
+	"strings"
+)
+
+// tagOptions is the parsed form of an `etcd:"name,opt,key=value"` struct tag.
+// A "regex=" option has to come last, as it extends to the end of the tag.
+type tagOptions struct {
+	name   string
+	params map[string]string
+}
+
+func parseTag(tag string) tagOptions {
+	params := strings.Split(tag, ",")
+	t := tagOptions{name: params[0]}
+	for i, param := range params[1:] {
+		if param == "" {
+			continue
+		}
+		if strings.HasPrefix(param, "regex=") {
+			// A regular expression may contain commas, so it takes the
+			// rest of the tag.
+			param = strings.Join(params[i+1:], ",")
+		}
+		if t.params == nil {
+			t.params = make(map[string]string)
+		}
+		kv := strings.SplitN(param, "=", 2)
+		if len(kv) == 2 {
+			t.params[kv[0]] = kv[1]
+		} else {
+			t.params[kv[0]] = ""
+		}
+		if kv[0] == "regex" {
+			break
+		}
+	}
+	return t
+}
+
+func (t tagOptions) has(opt string) bool {
+	_, ok := t.params[opt]
+	return ok
+}
+
+func (t tagOptions) get(opt string) string {
+	return t.params[opt]
+}
+
+// without returns a copy of t without option opt.
+func (t tagOptions) without(opt string) tagOptions {
+	c := tagOptions{name: t.name, params: make(map[string]string, len(t.params))}
+	for k, v := range t.params {
+		if k != opt {
+			c.params[k] = v
+		}
+	}
+	return c
+}
@@ -38,7 +38,12 @@ func (a *KeysAPIMock) Create(ctx context.Context, key, value string) (*client.Re
 }
 
 func (a *KeysAPIMock) CreateInOrder(ctx context.Context, dir, value string, opts *client.CreateInOrderOptions) (*client.Response, error) {
-	panic("not implemented")
+	args := a.Called(ctx, dir, value, opts)
+	if r, ok := args.Get(0).(*client.Response); ok {
+		return r, args.Error(1)
+	}
+
+	return nil, args.Error(1)
 }
 
 func (a *KeysAPIMock) Update(ctx context.Context, key, value string) (*client.Response, error) {
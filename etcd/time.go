@@ -0,0 +1,60 @@
+package etcd
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// Special values of the "layout" tag option.
+const (
+	layoutUnix      = "unix"
+	layoutUnixMilli = "unixms"
+)
+
+// formatTime formats t with the "layout" tag option: a time.Format layout,
+// "unix" for Unix seconds or "unixms" for Unix milliseconds. The default is
+// time.RFC3339Nano.
+func formatTime(t time.Time, layout string) string {
+	switch layout {
+	case "":
+		return t.Format(time.RFC3339Nano)
+	case layoutUnix:
+		return strconv.FormatInt(t.Unix(), 10)
+	case layoutUnixMilli:
+		return strconv.FormatInt(t.UnixNano()/int64(time.Millisecond), 10)
+	default:
+		return t.Format(layout)
+	}
+}
+
+// parseTime is the inverse of formatTime. It also accepts the quoted JSON
+// form earlier versions stored time.Time values in.
+func parseTime(s string, layout string) (time.Time, error) {
+	var t time.Time
+	if strings.HasPrefix(s, `"`) {
+		err := json.Unmarshal([]byte(s), &t)
+		return t, err
+	}
+
+	switch layout {
+	case "":
+		return time.Parse(time.RFC3339Nano, s)
+	case layoutUnix, layoutUnixMilli:
+		n, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return t, fmt.Errorf("invalid unix time %q", s)
+		}
+		if layout == layoutUnix {
+			return time.Unix(n, 0), nil
+		}
+		return time.Unix(0, n*int64(time.Millisecond)), nil
+	default:
+		return time.Parse(layout, s)
+	}
+}
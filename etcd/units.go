@@ -0,0 +1,202 @@
+package etcd
+
+import (
+	"fmt"
+	"math"
+	"math/big"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Multipliers of the byte size suffixes accepted for `unit=bytes` fields.
+var byteUnits = map[string]int64{
+	"":    1,
+	"b":   1,
+	"k":   1e3,
+	"kb":  1e3,
+	"kib": 1 << 10,
+	"m":   1e6,
+	"mb":  1e6,
+	"mib": 1 << 20,
+	"g":   1e9,
+	"gb":  1e9,
+	"gib": 1 << 30,
+	"t":   1e12,
+	"tb":  1e12,
+	"tib": 1 << 40,
+	"p":   1e15,
+	"pb":  1e15,
+	"pib": 1 << 50,
+	"e":   1e18,
+	"eb":  1e18,
+	"eib": 1 << 60,
+}
+
+// canonicalByteUnits are tried in order when formatting byte sizes, so they
+// are sorted by size across both families.
+var canonicalByteUnits = []struct {
+	name string
+	size int64
+}{
+	{"EiB", 1 << 60}, {"EB", 1e18},
+	{"PiB", 1 << 50}, {"PB", 1e15},
+	{"TiB", 1 << 40}, {"TB", 1e12},
+	{"GiB", 1 << 30}, {"GB", 1e9},
+	{"MiB", 1 << 20}, {"MB", 1e6},
+	{"KiB", 1 << 10}, {"KB", 1e3},
+}
+
+// durationUnits are the values of the "unit" tag option for integer fields
+// holding a number of time units.
+var durationUnits = map[string]time.Duration{
+	"ns": time.Nanosecond,
+	"us": time.Microsecond,
+	"ms": time.Millisecond,
+	"s":  time.Second,
+	"m":  time.Minute,
+	"h":  time.Hour,
+	"d":  24 * time.Hour,
+}
+
+const day = 24 * time.Hour
+
+// parseDuration is time.ParseDuration accepting a leading number of days,
+// e.g. "90d" or "1d12h".
+func parseDuration(s string) (time.Duration, error) {
+	i := strings.IndexByte(s, 'd')
+	if i < 0 {
+		return time.ParseDuration(s)
+	}
+
+	days, err := strconv.ParseFloat(s[:i], 64)
+	if err != nil || math.Abs(days*float64(day)) >= 1<<63 {
+		return 0, fmt.Errorf("invalid duration %q", s)
+	}
+	d := time.Duration(days * float64(day))
+
+	if rest := s[i+1:]; rest != "" {
+		r, err := time.ParseDuration(rest)
+		if err != nil {
+			return 0, fmt.Errorf("invalid duration %q", s)
+		}
+		if days < 0 {
+			r = -r
+		}
+		d += r
+	}
+	return d, nil
+}
+
+// formatDuration is the inverse of parseDuration; whole days are written
+// as "90d".
+func formatDuration(d time.Duration) string {
+	if d != 0 && d%day == 0 {
+		return strconv.FormatInt(int64(d/day), 10) + "d"
+	}
+	return d.String()
+}
+
+// parseUnit parses s for an integer field with the "unit" tag option. Plain
+// numbers are taken as a count of unit, suffixed values are converted. The
+// result is exact, so large integers don't lose precision.
+func parseUnit(s string, unit string) (*big.Rat, error) {
+	if unit == "bytes" {
+		s = strings.TrimSpace(s)
+		i := strings.IndexFunc(s, func(r rune) bool {
+			return (r < '0' || r > '9') && r != '.' && r != '-' && r != '+'
+		})
+		if i < 0 {
+			i = len(s)
+		}
+		multiplier, ok := byteUnits[strings.ToLower(strings.TrimSpace(s[i:]))]
+		if !ok {
+			return nil, fmt.Errorf("invalid size %q", s)
+		}
+		n, ok := new(big.Rat).SetString(s[:i])
+		if !ok {
+			return nil, fmt.Errorf("invalid size %q", s)
+		}
+		return n.Mul(n, new(big.Rat).SetInt64(multiplier)), nil
+	}
+
+	size, ok := durationUnits[unit]
+	if !ok {
+		return nil, fmt.Errorf("unknown unit %q", unit)
+	}
+	if n, ok := new(big.Rat).SetString(s); ok {
+		return n, nil
+	}
+	d, err := parseDuration(s)
+	if err != nil {
+		return nil, err
+	}
+	return big.NewRat(int64(d), int64(size)), nil
+}
+
+// decodeUnit decodes s into an integer field with the "unit" tag option.
+func decodeUnit(s string, value reflect.Value, unit string) error {
+	r, err := parseUnit(s, unit)
+	if err != nil {
+		return err
+	}
+	if !r.IsInt() {
+		return fmt.Errorf("%q is not a whole number of %s", s, unit)
+	}
+	n := r.Num()
+
+	switch value.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if !n.IsInt64() || value.OverflowInt(n.Int64()) {
+			return &RangeError{Value: s, Type: value.Type()}
+		}
+		value.SetInt(n.Int64())
+
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		if n.Sign() < 0 || !n.IsUint64() || value.OverflowUint(n.Uint64()) {
+			return &RangeError{Value: s, Type: value.Type()}
+		}
+		value.SetUint(n.Uint64())
+
+	default:
+		return fmt.Errorf("unit can't be applied to %s", value.Type())
+	}
+	return nil
+}
+
+// formatUnit writes an integer field with the "unit" tag option in its
+// canonical form: the largest byte unit that divides the size exactly, or
+// the duration as formatted by formatDuration.
+func formatUnit(value reflect.Value, unit string) (string, error) {
+	var n int64
+	switch value.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n = value.Int()
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		if value.Uint() > math.MaxInt64 {
+			return strconv.FormatUint(value.Uint(), 10), nil
+		}
+		n = int64(value.Uint())
+	default:
+		return "", fmt.Errorf("unit can't be applied to %s", value.Type())
+	}
+
+	if unit == "bytes" {
+		for _, u := range canonicalByteUnits {
+			if n != 0 && n%u.size == 0 {
+				return strconv.FormatInt(n/u.size, 10) + u.name, nil
+			}
+		}
+		return strconv.FormatInt(n, 10), nil
+	}
+
+	size, ok := durationUnits[unit]
+	if !ok {
+		return "", fmt.Errorf("unknown unit %q", unit)
+	}
+	if n > math.MaxInt64/int64(size) || n < math.MinInt64/int64(size) {
+		return strconv.FormatInt(n, 10), nil
+	}
+	return formatDuration(time.Duration(n) * size), nil
+}
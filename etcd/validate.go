@@ -0,0 +1,198 @@
+package etcd
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Validator is implemented by types that check their own consistency. The
+// decoder calls Validate after a struct has been decoded.
+type Validator interface {
+	Validate() error
+}
+
+var validatorType = reflect.TypeOf(new(Validator)).Elem()
+
+// ValidationError is returned by the decoder when a value fails one of the
+// min, max, oneof, regex or required tag options or its Validate method.
+type ValidationError struct {
+	Key string
+	Err error
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Key, e.Err)
+}
+
+func (e *ValidationError) Unwrap() error {
+	return e.Err
+}
+
+// validateField checks value, stored under key, against the validation
+// options of tag.
+func validateField(key string, value reflect.Value, tag tagOptions) error {
+	if err := checkField(value, tag); err != nil {
+		return &ValidationError{Key: key, Err: err}
+	}
+	return nil
+}
+
+func checkField(value reflect.Value, tag tagOptions) error {
+	for value.Kind() == reflect.Ptr || value.Kind() == reflect.Interface {
+		if value.IsNil() {
+			if tag.has("required") {
+				return errors.New("value is required")
+			}
+			return nil
+		}
+		value = value.Elem()
+	}
+
+	if tag.has("required") && value.IsZero() {
+		return errors.New("value is required")
+	}
+
+	if tag.has("min") {
+		if err := checkBound(value, tag.get("min"), false); err != nil {
+			return err
+		}
+	}
+
+	if tag.has("max") {
+		if err := checkBound(value, tag.get("max"), true); err != nil {
+			return err
+		}
+	}
+
+	if tag.has("oneof") {
+		s, err := valueToString(value)
+		if err != nil {
+			return err
+		}
+		allowed := strings.Fields(tag.get("oneof"))
+		found := false
+		for _, a := range allowed {
+			if s == a {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("%q is not one of %s", s, strings.Join(allowed, ", "))
+		}
+	}
+
+	if tag.has("regex") {
+		if value.Kind() != reflect.String {
+			return fmt.Errorf("regex can't be applied to %s", value.Type())
+		}
+		re, err := regexp.Compile(tag.get("regex"))
+		if err != nil {
+			return err
+		}
+		if !re.MatchString(value.String()) {
+			return fmt.Errorf("%q does not match %s", value.String(), re)
+		}
+	}
+
+	return nil
+}
+
+// checkBound compares numbers by value and strings, slices and maps by
+// length. Bounds of time.Duration values are durations, e.g. "1s" or "7d".
+// Integers are compared exactly, so large int64 and uint64 bounds work.
+func checkBound(value reflect.Value, bound string, isMax bool) error {
+	var actual, limit float64
+	var err error
+
+	switch value.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		exact := new(big.Rat)
+		if value.Type() == durationType {
+			var d time.Duration
+			d, err = parseDuration(bound)
+			exact.SetInt64(int64(d))
+		} else if _, ok := exact.SetString(bound); !ok {
+			err = errors.New("not a number")
+		}
+		if err != nil {
+			return fmt.Errorf("invalid bound %q: %s", bound, err)
+		}
+		return compareBound(value, new(big.Rat).SetInt64(value.Int()).Cmp(exact), bound, isMax)
+
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		exact, ok := new(big.Rat).SetString(bound)
+		if !ok {
+			return fmt.Errorf("invalid bound %q: not a number", bound)
+		}
+		n := new(big.Int).SetUint64(value.Uint())
+		return compareBound(value, new(big.Rat).SetInt(n).Cmp(exact), bound, isMax)
+
+	case reflect.Float32, reflect.Float64:
+		actual = value.Float()
+		limit, err = strconv.ParseFloat(bound, 64)
+
+	case reflect.String, reflect.Slice, reflect.Map, reflect.Array:
+		actual = float64(value.Len())
+		limit, err = strconv.ParseFloat(bound, 64)
+		if err == nil {
+			if isMax && actual > limit {
+				return fmt.Errorf("length %d is greater than %s", value.Len(), bound)
+			}
+			if !isMax && actual < limit {
+				return fmt.Errorf("length %d is less than %s", value.Len(), bound)
+			}
+			return nil
+		}
+
+	default:
+		return fmt.Errorf("min and max can't be applied to %s", value.Type())
+	}
+
+	if err != nil {
+		return fmt.Errorf("invalid bound %q: %s", bound, err)
+	}
+
+	cmp := 0
+	if actual > limit {
+		cmp = 1
+	} else if actual < limit {
+		cmp = -1
+	}
+	return compareBound(value, cmp, bound, isMax)
+}
+
+// compareBound reports value as out of bounds given the result of comparing
+// it with bound.
+func compareBound(value reflect.Value, cmp int, bound string, isMax bool) error {
+	s, _ := valueToString(value)
+	if isMax && cmp > 0 {
+		return fmt.Errorf("%s is greater than %s", s, bound)
+	}
+	if !isMax && cmp < 0 {
+		return fmt.Errorf("%s is less than %s", s, bound)
+	}
+	return nil
+}
+
+// validateStruct calls the Validate method of the struct value stored under
+// key, if it has one.
+func validateStruct(key string, value reflect.Value) error {
+	if !value.CanAddr() || !value.Addr().Type().Implements(validatorType) {
+		return nil
+	}
+
+	if err := value.Addr().Interface().(Validator).Validate(); err != nil {
+		if _, ok := err.(*ValidationError); ok {
+			return err
+		}
+		return &ValidationError{Key: key, Err: err}
+	}
+	return nil
+}
@@ -0,0 +1,47 @@
+package etcd
+
+import (
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+var weakBools = map[string]bool{
+	"yes": true,
+	"y":   true,
+	"on":  true,
+	"no":  false,
+	"n":   false,
+	"off": false,
+}
+
+// decodeWeak is decodePrimitive for WithWeaklyTypedInput: empty values are
+// zero, bools accept yes/no/on/off and integers accept integral floats
+// such as "1.0".
+func decodeWeak(nodeValue string, value reflect.Value) error {
+	s := strings.TrimSpace(nodeValue)
+	if s == "" && value.Kind() != reflect.String {
+		value.Set(reflect.Zero(value.Type()))
+		return nil
+	}
+
+	switch value.Kind() {
+	case reflect.Bool:
+		if b, ok := weakBools[strings.ToLower(s)]; ok {
+			value.SetBool(b)
+			return nil
+		}
+
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		if value.Type() == durationType {
+			break
+		}
+		if f, err := strconv.ParseFloat(s, 64); err == nil && f == math.Trunc(f) && strings.ContainsAny(s, ".eE") {
+			s = strconv.FormatFloat(f, 'f', -1, 64)
+		}
+	}
+
+	return decodePrimitive(s, value)
+}